@@ -16,9 +16,85 @@ type File interface {
 	Compose(d delta.Delta) error
 }
 
+// RichFile is implemented by Files backed by a Quill document, so commands
+// can see the attributes and embeds behind the text.
+type RichFile interface {
+	File
+	// Contents returns the part of the document between start and end.
+	Contents(start, end int64) delta.Delta
+}
+
+// MarkFile is implemented by Files keeping the mark set by the k command
+// from one Run to the next. For other Files the mark lasts as long as the
+// file is in a Session, or for a single Run without one.
+type MarkFile interface {
+	File
+	SetMark(q0, q1 int64)
+	Mark() (q0, q1 int64)
+}
+
+// NamedFile is implemented by Files keeping the name set by the e and f
+// commands from one Run to the next. Without a Session, only a NamedFile
+// can be given a name.
+type NamedFile interface {
+	File
+	Name() string
+	SetName(name string)
+}
+
+// Undoer is implemented by Files keeping a history of composed deltas, the
+// u command needs it. Undo and Redo return false when there is nothing to
+// undo or redo.
+type Undoer interface {
+	Undo() bool
+	Redo() bool
+}
+
 type Context struct {
-	File    File
+	File File
+	// Session, when set, makes commands run over all of its files starting
+	// from its current one, File is ignored in this case.
+	Session *Session
 	Printer io.Writer
+	// Runner runs the external commands of <, >, | and !, an ExecRunner is
+	// used when it is nil. The Session keeps that ExecRunner, without a
+	// Session a new one is made for every Run and cd fails, as the
+	// directory it sets would be lost.
+	Runner Runner
+	// FS is where r, w and e read and write files, it defaults to the
+	// files of the operating system.
+	FS FS
+	// Lenient drops the rule of sam that the changes made by a command must
+	// be in ascending order and not overlap. Changes are then composed one
+	// after another, overlapping ones can give surprising results.
+	Lenient bool
+	// Warn, when set, is called for conditions that do not stop a command
+	// but are worth telling the user about, such as a wrapped search.
+	Warn func(w Warning)
+	// PrintFormat is how p prints when it is not given a format such as
+	// p html.
+	PrintFormat PrintFormat
+	// Schema, when set, lists the formats and embeds the files may hold.
+	// A command whose changes do not satisfy it fails before any File
+	// composes them.
+	Schema *Schema
+}
+
+// Warning is a non-fatal condition reported through Context.Warn.
+type Warning int
+
+const (
+	// SearchWrapped means a regexp address ran off one end of the file and
+	// found its match after continuing from the other end.
+	SearchWrapped Warning = iota
+)
+
+func (w Warning) String() string {
+	switch w {
+	case SearchWrapped:
+		return "search wrapped"
+	}
+	return fmt.Sprintf("warning %d", int(w))
 }
 
 func Compile(cmd string) (Cmd, error) {
@@ -34,6 +110,9 @@ func Compile(cmd string) (Cmd, error) {
 }
 
 func (c Cmd) Run(context Context) error {
+	if context.Session == nil && context.File == nil {
+		return fmt.Errorf("No file to run the command on!")
+	}
 	innerContext, err := newInnerContext(context)
 	if err != nil {
 		return err
@@ -42,5 +121,5 @@ func (c Cmd) Run(context Context) error {
 	if err != nil {
 		return err
 	}
-	return innerContext.File.Commit()
+	return innerContext.files.commit()
 }
@@ -0,0 +1,79 @@
+// Command ssam runs a sam command on the files below a directory, like a
+// codemod. The files change only if the command succeeds on all of them.
+//
+// Usage:
+//
+//	ssam [-C dir] [-i pattern]... [-x pattern]... [-n] [-f file | command]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	editor "xuejie.space/c/go-quill-editor"
+)
+
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func main() {
+	tree := &editor.Tree{}
+	flag.StringVar(&tree.Root, "C", ".", "run on the files below `dir`")
+	flag.Var((*patterns)(&tree.Include), "i", "include files matching `pattern`, may be repeated")
+	flag.Var((*patterns)(&tree.Exclude), "x", "exclude files and directories matching `pattern`, may be repeated")
+	dryRun := flag.Bool("n", false, "only list the files that would change")
+	script := flag.String("f", "", "read the command from `file`")
+	flag.Parse()
+
+	command := strings.Join(flag.Args(), " ")
+	if *script != "" {
+		data, err := os.ReadFile(*script)
+		if err != nil {
+			fail(err)
+		}
+		command = string(data)
+	}
+	cmd, err := editor.Compile(command)
+	if err != nil {
+		fail(err)
+	}
+	run, err := tree.Run(cmd, editor.Context{
+		FS: editor.DirFS(tree.Root),
+	})
+	if err != nil {
+		fail(err)
+	}
+	for _, f := range run.Files {
+		if f.Skipped {
+			fmt.Fprintf(os.Stderr, "ssam: skipping %s, not UTF-8 text\n", f.Name)
+		}
+		os.Stdout.Write(f.Output)
+	}
+	if err := run.Err(); err != nil {
+		fail(err)
+	}
+	for _, f := range run.Modified() {
+		fmt.Fprintf(os.Stderr, "M %s\n", f.Name)
+	}
+	if *dryRun {
+		return
+	}
+	if err := run.Commit(); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "ssam: %v\n", err)
+	os.Exit(1)
+}
@@ -2,9 +2,17 @@ package editor
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
+	"io/ioutil"
 	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
 )
 
 type defaultAddress byte
@@ -17,20 +25,55 @@ const (
 
 type innerContext struct {
 	File    *innerFile
+	files   *innerSession
 	Printer io.Writer
+	Runner  Runner
+	// runOnly is set when Runner is made for this Run alone, so a
+	// directory set with cd would be lost.
+	runOnly     bool
+	FS          FS
+	Warn        func(w Warning)
+	PrintFormat PrintFormat
 }
 
 func newInnerContext(context Context) (innerContext, error) {
-	innerFile, err := newInnerFile(context.File)
+	files, err := newInnerSession(context)
 	if err != nil {
 		return innerContext{}, err
 	}
+	runner := context.Runner
+	if runner == nil && context.Session != nil {
+		if context.Session.runner == nil {
+			context.Session.runner = &ExecRunner{}
+		}
+		runner = context.Session.runner
+	}
+	runOnly := runner == nil
+	if runOnly {
+		runner = &ExecRunner{}
+	}
+	fsys := context.FS
+	if fsys == nil {
+		fsys = osFS{}
+	}
 	return innerContext{
-		File:    innerFile,
-		Printer: context.Printer,
+		File:        files.current,
+		files:       files,
+		Printer:     context.Printer,
+		Runner:      runner,
+		runOnly:     runOnly,
+		FS:          fsys,
+		Warn:        context.Warn,
+		PrintFormat: context.PrintFormat,
 	}, nil
 }
 
+func (c innerContext) warn(w Warning) {
+	if c.Warn != nil {
+		c.Warn(w)
+	}
+}
+
 type cmdtab struct {
 	cmdc    uint16                                    // command character
 	text    bool                                      // takes a textual argument?
@@ -80,6 +123,17 @@ func init() {
 			token:   nil,
 			fn:      aCmd,
 		},
+		{
+			cmdc:    'b',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   0,
+			token:   lineTokens,
+			fn:      bCmd,
+		},
 		{
 			cmdc:    'c',
 			text:    true,
@@ -102,6 +156,28 @@ func init() {
 			token:   nil,
 			fn:      dCmd,
 		},
+		{
+			cmdc:    'e',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   0,
+			token:   wordTokens,
+			fn:      eCmd,
+		},
+		{
+			cmdc:    'f',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   0,
+			token:   wordTokens,
+			fn:      fCmd,
+		},
 		{
 			cmdc:    'g',
 			text:    false,
@@ -124,6 +200,17 @@ func init() {
 			token:   nil,
 			fn:      iCmd,
 		},
+		{
+			cmdc:    'k',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrDot,
+			count:   0,
+			token:   nil,
+			fn:      kCmd,
+		},
 		{
 			cmdc:    'm',
 			text:    false,
@@ -135,6 +222,17 @@ func init() {
 			token:   nil,
 			fn:      mCmd,
 		},
+		{
+			cmdc:    'n',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   0,
+			token:   nil,
+			fn:      nCmd,
+		},
 		{
 			cmdc:    'p',
 			text:    false,
@@ -143,9 +241,20 @@ func init() {
 			defcmd:  0,
 			defaddr: defAddrDot,
 			count:   0,
-			token:   nil,
+			token:   lineTokens,
 			fn:      pCmd,
 		},
+		{
+			cmdc:    'r',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrDot,
+			count:   0,
+			token:   wordTokens,
+			fn:      eCmd,
+		},
 		{
 			cmdc:    's',
 			text:    false,
@@ -168,6 +277,17 @@ func init() {
 			token:   nil,
 			fn:      tCmd,
 		},
+		{
+			cmdc:    'u',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   2,
+			token:   nil,
+			fn:      uCmd,
+		},
 		{
 			cmdc:    'v',
 			text:    false,
@@ -179,6 +299,17 @@ func init() {
 			token:   nil,
 			fn:      gCmd,
 		},
+		{
+			cmdc:    'w',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrAll,
+			count:   0,
+			token:   wordTokens,
+			fn:      wCmd,
+		},
 		{
 			cmdc:    'x',
 			text:    false,
@@ -201,6 +332,50 @@ func init() {
 			token:   nil,
 			fn:      xCmd,
 		},
+		{
+			cmdc:    'B',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   0,
+			token:   lineTokens,
+			fn:      bCmd,
+		},
+		{
+			cmdc:    'D',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   0,
+			token:   lineTokens,
+			fn:      dFilesCmd,
+		},
+		{
+			cmdc:    'X',
+			text:    false,
+			regexp:  true,
+			addr:    false,
+			defcmd:  'f',
+			defaddr: defAddrNo,
+			count:   0,
+			token:   nil,
+			fn:      xFilesCmd,
+		},
+		{
+			cmdc:    'Y',
+			text:    false,
+			regexp:  true,
+			addr:    false,
+			defcmd:  'f',
+			defaddr: defAddrNo,
+			count:   0,
+			token:   nil,
+			fn:      xFilesCmd,
+		},
 		{
 			cmdc:    '=',
 			text:    false,
@@ -212,6 +387,138 @@ func init() {
 			token:   lineTokens,
 			fn:      eqCmd,
 		},
+		{
+			cmdc:    '<',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrDot,
+			count:   0,
+			token:   lineTokens,
+			fn:      pipeCmd,
+		},
+		{
+			cmdc:    '|',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrDot,
+			count:   0,
+			token:   lineTokens,
+			fn:      pipeCmd,
+		},
+		{
+			cmdc:    '>',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrDot,
+			count:   0,
+			token:   lineTokens,
+			fn:      pipeCmd,
+		},
+		{
+			cmdc:    '!',
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   0,
+			token:   lineTokens,
+			fn:      pipeCmd,
+		},
+		{
+			cmdc:    'c' | 0x100,
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrNo,
+			count:   0,
+			token:   wordTokens,
+			fn:      cdCmd,
+		},
+		{
+			cmdc:    'e' | 0x100,
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrDot,
+			count:   0,
+			token:   lineTokens,
+			fn:      embedCmd,
+		},
+		{
+			cmdc:    'f' | 0x100,
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrDot,
+			count:   0,
+			token:   lineTokens,
+			fn:      fmtCmd,
+		},
+		{
+			cmdc:    'l' | 0x100,
+			text:    false,
+			regexp:  false,
+			addr:    false,
+			defcmd:  0,
+			defaddr: defAddrDot,
+			count:   0,
+			token:   lineTokens,
+			fn:      lfmtCmd,
+		},
+		{
+			cmdc:    'g' | 0x100,
+			text:    false,
+			regexp:  true,
+			addr:    false,
+			defcmd:  'p',
+			defaddr: defAddrDot,
+			count:   0,
+			token:   nil,
+			fn:      gCmd,
+		},
+		{
+			cmdc:    'v' | 0x100,
+			text:    false,
+			regexp:  true,
+			addr:    false,
+			defcmd:  'p',
+			defaddr: defAddrDot,
+			count:   0,
+			token:   nil,
+			fn:      gCmd,
+		},
+		{
+			cmdc:    'x' | 0x100,
+			text:    false,
+			regexp:  true,
+			addr:    false,
+			defcmd:  'p',
+			defaddr: defAddrDot,
+			count:   0,
+			token:   nil,
+			fn:      xCmd,
+		},
+		{
+			cmdc:    'y' | 0x100,
+			text:    false,
+			regexp:  true,
+			addr:    false,
+			defcmd:  'p',
+			defaddr: defAddrDot,
+			count:   0,
+			token:   nil,
+			fn:      xCmd,
+		},
 	}
 }
 
@@ -226,6 +533,9 @@ func cmdLookup(cmdc uint16) *cmdtab {
 
 func cmdExec(c Cmd, context innerContext) error {
 	ct := cmdLookup(c.cmdc)
+	if context.File == nil && !fileAddress(c.addr) && (ct == nil || ct.defaddr != defAddrNo) {
+		return fmt.Errorf("No current file!")
+	}
 	if ct != nil && ct.defaddr != defAddrNo {
 		if c.addr == nil && c.cmdc != '\n' {
 			c.addr = &Addr{
@@ -243,29 +553,39 @@ func cmdExec(c Cmd, context innerContext) error {
 			}
 		}
 		if c.addr != nil {
-			a, err := cmdAddress(c.addr, context, 0)
+			a, f, err := cmdAddress(c.addr, context, 0)
 			if err != nil {
 				return err
 			}
+			context.File = f
+			context.files.current = f
 			context.File.Select(a[0], a[1])
 		}
 	}
 	switch c.cmdc {
 	case '{':
 		if c.addr != nil {
-			a, err := cmdAddress(c.addr, context, 0)
+			a, f, err := cmdAddress(c.addr, context, 0)
 			if err != nil {
 				return err
 			}
+			context.File = f
+			context.files.current = f
 			context.File.Select(a[0], a[1])
 		}
-		q0, q1 := context.File.Dot()
+		f := context.File
+		q0, q1 := f.Dot()
 		for cc := c.cmd; cc != nil; cc = cc.next {
-			context.File.Select(q0, q1)
+			if context.File == f {
+				f.Select(q0, q1)
+			}
 			err := cmdExec(*cc, context)
 			if err != nil {
 				return err
 			}
+			// b, B and D change the current file, the commands after them
+			// run in the file they leave current, or fail without one.
+			context.File = context.files.current
 		}
 	default:
 		if ct == nil {
@@ -276,30 +596,46 @@ func cmdExec(c Cmd, context innerContext) error {
 	return nil
 }
 
-func cmdAddress(addr *Addr, context innerContext, sign int) ([]int64, error) {
-	a0, a1 := context.File.Dot()
-	result := []int64{a0, a1}
+// fileAddress reports if addr starts with a "regexp" file part, so it does
+// not need a current file to start from.
+func fileAddress(addr *Addr) bool {
+	for addr != nil && (addr.t == ',' || addr.t == ';') {
+		addr = addr.left
+	}
+	return addr != nil && addr.t == '"'
+}
+
+// cmdAddress evaluates addr starting from dot, it returns the resulting range
+// together with the file it lies in, which differs from context.File when
+// the address contains a "regexp" file part.
+func cmdAddress(addr *Addr, context innerContext, sign int) ([]int64, *innerFile, error) {
+	result := []int64{0, 0}
+	if context.File != nil {
+		result[0], result[1] = context.File.Dot()
+	}
 	var err error
 	for addr != nil {
 		switch addr.t {
 		case '#':
+			// #n counts in the unit of the file
+			f := context.File
 			if sign == 0 {
-				result[0] = addr.num
-				result[1] = addr.num
+				result[0] = f.bytePosition(addr.num)
+				result[1] = result[0]
 			} else if sign < 0 {
-				result[0] -= addr.num
+				result[0] = f.bytePosition(f.unitPosition(result[0]) - addr.num)
 				result[1] = result[0]
 			} else if sign > 0 {
-				result[1] += addr.num
+				result[1] = f.bytePosition(f.unitPosition(result[1]) + addr.num)
 				result[0] = result[1]
 			}
-			if result[0] > context.File.Len() {
-				return nil, fmt.Errorf("Address out of range!")
+			if result[0] < 0 || result[0] > context.File.Len() {
+				return nil, nil, fmt.Errorf("Address out of range!")
 			}
 		case 'l':
 			location, err := extractLineAddress(context, addr.num, sign, result)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			result = location
 		case '.':
@@ -308,7 +644,10 @@ func cmdAddress(addr *Addr, context innerContext, sign int) ([]int64, error) {
 			l := context.File.Len()
 			result[0], result[1] = l, l
 		case '\'':
-			return nil, fmt.Errorf("Can't handle '")
+			result[0], result[1] = context.File.Mark()
+			if result[1] > context.File.Len() {
+				return nil, nil, fmt.Errorf("Mark out of range!")
+			}
 		case '?':
 			sign = -sign
 			if sign == 0 {
@@ -316,53 +655,81 @@ func cmdAddress(addr *Addr, context innerContext, sign int) ([]int64, error) {
 			}
 			fallthrough
 		case '/':
-			// TODO: adding wrapping support later
 			start := result[1]
-			end := context.File.Len()
 			if sign < 0 {
-				start = 0
-				end = result[0]
+				start = result[0]
 			}
-			location, err := regexpSearch(addr.re, context, start, end, sign)
+			location, wrapped, err := regexpSearch(addr.re, context, start, sign)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+			if location == nil {
+				return nil, nil, fmt.Errorf("No match for regexp")
+			}
+			if wrapped {
+				context.warn(SearchWrapped)
+			}
+			result = location
+		case '@':
+			start := result[1]
+			if sign < 0 {
+				start = result[0]
+			}
+			location, wrapped, err := attributeSearch(addr.attr, addr.re, context, start, sign)
+			if err != nil {
+				return nil, nil, err
 			}
 			if location == nil {
-				return nil, fmt.Errorf("No match for regexp")
+				return nil, nil, fmt.Errorf("No match for attribute %s", addr.attr)
+			}
+			if wrapped {
+				context.warn(SearchWrapped)
 			}
 			result = location
 		case '"':
-			return nil, fmt.Errorf("Implement \" later")
+			f, err := context.files.matchFile(addr.re)
+			if err != nil {
+				return nil, nil, err
+			}
+			context.File = f
+			result[0], result[1] = f.Dot()
 		case '*':
 			result[0], result[1] = 0, context.File.Len()
 		case ',':
 			fallthrough
 		case ';':
-			// TODO: deal with file selection later
 			a1 := []int64{0, 0}
+			f1 := context.File
 			if addr.left != nil {
-				a1, err = cmdAddress(addr.left, context, 0)
+				a1, f1, err = cmdAddress(addr.left, context, 0)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 			}
+			// The right side is in the file of the left side, as in
+			// "b\.md"1,2 or "b\.md",.
+			context.File = f1
 			if addr.t == ';' {
 				result = a1
 				context.File.Select(a1[0], a1[1])
 			}
 			l := context.File.Len()
 			a2 := []int64{l, l}
+			f2 := context.File
 			if addr.next != nil {
-				a2, err = cmdAddress(addr.next, context, 0)
+				a2, f2, err = cmdAddress(addr.next, context, 0)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 			}
+			if f1 != f2 {
+				return nil, nil, fmt.Errorf("Addresses in different files")
+			}
 			result[0], result[1] = a1[0], a2[1]
 			if result[1] < result[0] {
-				return nil, fmt.Errorf("Addresses out of order")
+				return nil, nil, fmt.Errorf("Addresses out of order")
 			}
-			return result, nil
+			return result, f1, nil
 		case '+':
 			fallthrough
 		case '-':
@@ -373,15 +740,15 @@ func cmdAddress(addr *Addr, context innerContext, sign int) ([]int64, error) {
 			if addr.next == nil || addr.next.t == '+' || addr.next.t == '-' {
 				result, err = extractLineAddress(context, 1, sign, result)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 			}
 		default:
-			return nil, fmt.Errorf("Invalid addresss type %c when setting dot!", addr.t)
+			return nil, nil, fmt.Errorf("Invalid addresss type %c when setting dot!", addr.t)
 		}
 		addr = addr.next
 	}
-	return result, nil
+	return result, context.File, nil
 }
 
 func nlCmd(context innerContext, cmd Cmd) error {
@@ -397,79 +764,279 @@ func nlCmd(context innerContext, cmd Cmd) error {
 		if err != nil {
 			return err
 		}
-		addr[1] = a[1]
-		if addr[0] == q0 && addr[1] == q1 {
-			addr, err = extractLineAddress(context, 1, 1, []int64{q0, q1})
-			if err != nil {
-				return err
-			}
-		}
+		addr[1] = a[1]
+		if addr[0] == q0 && addr[1] == q1 {
+			addr, err = extractLineAddress(context, 1, 1, []int64{q0, q1})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	context.File.Select(addr[0], addr[1])
+	return nil
+}
+
+func aCmd(context innerContext, cmd Cmd) error {
+	_, q1 := context.File.Dot()
+	if len(cmd.text) > 0 {
+		data := formattedText(context, []byte(cmd.text), q1, q1)
+		l, err := context.File.InsertDelta(data, q1)
+		if err != nil {
+			return err
+		}
+		if l != textLen(data) {
+			return fmt.Errorf("Wrong number of inserted characters!")
+		}
+	}
+	return nil
+}
+
+// bCmd switches to a file with b, B also opens the files it names that are
+// not in the menu yet.
+func bCmd(context innerContext, cmd Cmd) error {
+	names := strings.Fields(cmd.text)
+	if len(names) == 0 {
+		return fmt.Errorf("No file name!")
+	}
+	var f *innerFile
+	if cmd.cmdc == uint16('b') {
+		if len(names) > 1 {
+			return fmt.Errorf("b takes only one file name!")
+		}
+		f = context.files.lookup(names[0])
+		if f == nil {
+			return fmt.Errorf("%s not in menu", names[0])
+		}
+	} else {
+		for _, name := range names {
+			var err error
+			f, err = context.files.open(name, context.FS)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	context.files.current = f
+	return printMenuLine(context, f)
+}
+
+func cCmd(context innerContext, cmd Cmd) error {
+	q0, q1 := context.File.Dot()
+	return replaceText(context, q0, q1, []byte(cmd.text))
+}
+
+func cdCmd(context innerContext, cmd Cmd) error {
+	if context.runOnly {
+		return fmt.Errorf("cd needs a Session or a Runner to keep the directory in!")
+	}
+	return context.Runner.Chdir(strings.TrimSpace(cmd.text))
+}
+
+func dFilesCmd(context innerContext, cmd Cmd) error {
+	names := strings.Fields(cmd.text)
+	if len(names) == 0 {
+		if context.File == nil {
+			return fmt.Errorf("No current file!")
+		}
+		return context.files.close(context.File)
+	}
+	for _, name := range names {
+		f := context.files.lookup(name)
+		if f == nil {
+			return fmt.Errorf("%s not in menu", name)
+		}
+		err := context.files.close(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dCmd(context innerContext, cmd Cmd) error {
+	q0, q1 := context.File.Dot()
+	if q1 > q0 {
+		_, err := context.File.Delete(q0, q1)
+		return err
+	}
+	return nil
+}
+
+// eCmd reads a file for both e and r, e replaces the whole file and takes
+// the new name while r only replaces dot.
+func eCmd(context innerContext, cmd Cmd) error {
+	name, err := fileName(context, cmd)
+	if err != nil {
+		return err
+	}
+	data, err := fs.ReadFile(context.FS, name)
+	if err != nil {
+		return err
+	}
+	q0, q1 := context.File.Dot()
+	if cmd.cmdc == uint16('e') {
+		q0, q1 = 0, context.File.Len()
+		err = context.files.rename(context.File, name)
+		if err != nil {
+			return err
+		}
+		context.File.clean = true
+		return replaceDelta(context, q0, q1, *delta.New(nil).Insert(string(data), nil))
+	}
+	return replaceText(context, q0, q1, data)
+}
+
+func fCmd(context innerContext, cmd Cmd) error {
+	if name := strings.TrimSpace(cmd.text); name != "" {
+		err := context.files.rename(context.File, name)
+		if err != nil {
+			return err
+		}
+	}
+	return printMenuLine(context, context.File)
+}
+
+// embedCmd replaces dot with an embed, given as its type and value where
+// the value is parsed as JSON when it can be and taken as a string
+// otherwise.
+func embedCmd(context innerContext, cmd Cmd) error {
+	fields := strings.SplitN(strings.TrimSpace(cmd.text), " ", 2)
+	if len(fields) < 2 || fields[0] == "" {
+		return fmt.Errorf("Embed needs a type and a value!")
+	}
+	var value interface{}
+	valueText := strings.TrimSpace(fields[1])
+	if json.Unmarshal([]byte(valueText), &value) != nil {
+		value = valueText
+	}
+	if value == nil {
+		return fmt.Errorf("Embed needs a type and a value!")
+	}
+	q0, q1 := context.File.Dot()
+	d := delta.New(nil).InsertEmbed(delta.Embed{
+		Key:   fields[0],
+		Value: value,
+	}, nil)
+	return replaceDelta(context, q0, q1, *d)
+}
+
+func fmtCmd(context innerContext, cmd Cmd) error {
+	attrs, err := parseAttributes(cmd.text, nil)
+	if err != nil {
+		return err
+	}
+	q0, q1 := context.File.Dot()
+	return context.File.Format(q0, q1, attrs)
+}
+
+func gCmd(context innerContext, cmd Cmd) error {
+	q0, q1 := context.File.Dot()
+	var hasMatch bool
+	if cmd.attr != "" {
+		runs, err := attributeRuns(context, cmd.attr, cmd.re, q0, q1)
+		if err != nil {
+			return err
+		}
+		hasMatch = len(runs) > 0
+	} else {
+		re, err := compileRegexp(cmd.re)
+		if err != nil {
+			return err
+		}
+		reader := context.File.Reader(q0, q1)
+		hasMatch = re.FindReaderIndex(bufio.NewReader(reader)) != nil
+	}
+	isInverse := byte(cmd.cmdc) == 'v'
+	if (hasMatch && (!isInverse)) || ((!hasMatch) && isInverse) {
+		context.File.Select(q0, q1)
+		err := cmdExec(*cmd.cmd, context)
+		if err != nil {
+			return err
+		}
 	}
-	context.File.Select(addr[0], addr[1])
 	return nil
 }
 
-func aCmd(context innerContext, cmd Cmd) error {
-	_, q1 := context.File.Dot()
-	l := int64(0)
+func iCmd(context innerContext, cmd Cmd) error {
+	q0, _ := context.File.Dot()
 	if len(cmd.text) > 0 {
-		data := []byte(cmd.text)
-		l = context.File.Insert(data, q1)
-		if l != int64(len(data)) {
+		data := formattedText(context, []byte(cmd.text), q0, q0)
+		l, err := context.File.InsertDelta(data, q0)
+		if err != nil {
+			return err
+		}
+		if l != textLen(data) {
 			return fmt.Errorf("Wrong number of inserted characters!")
 		}
 	}
 	return nil
 }
 
-func cCmd(context innerContext, cmd Cmd) error {
-	q0, q1 := context.File.Dot()
-	return replaceText(context, q0, q1, []byte(cmd.text))
-}
-
-func dCmd(context innerContext, cmd Cmd) error {
+func kCmd(context innerContext, cmd Cmd) error {
 	q0, q1 := context.File.Dot()
-	if q1 > q0 {
-		context.File.Delete(q0, q1)
-	}
+	context.File.SetMark(q0, q1)
 	return nil
 }
 
-func gCmd(context innerContext, cmd Cmd) error {
-	re, err := compileRegexp(cmd.re)
+// lfmtCmd sets line formats on the newline ending each line dot touches,
+// key+=n and key-=n step numeric formats such as header or indent and
+// remove them once they drop to zero.
+func lfmtCmd(context innerContext, cmd Cmd) error {
+	steps := make(map[string]float64)
+	attrs, err := parseAttributes(cmd.text, steps)
 	if err != nil {
 		return err
 	}
 	q0, q1 := context.File.Dot()
-	reader := context.File.Reader(q0, q1)
-	location := re.FindReaderIndex(bufio.NewReader(reader))
-	hasMatch := location != nil
-	isInverse := cmd.cmdc == uint16('v')
-	if (hasMatch && (!isInverse)) || ((!hasMatch) && isInverse) {
-		context.File.Select(q0, q1)
-		err := cmdExec(*cmd.cmd, context)
+	lineAddr, err := extractLineAddress(context, 0, -1, []int64{q0, q0})
+	if err != nil {
+		return err
+	}
+	start := lineAddr[0]
+	end := start
+	for p := start; p == start || p < q1; p = lineAddr[1] {
+		lineAddr, err = extractLineAddress(context, 1, 1, []int64{p, p})
 		if err != nil {
 			return err
 		}
-	}
-	return nil
-}
-
-func iCmd(context innerContext, cmd Cmd) error {
-	q0, _ := context.File.Dot()
-	l := int64(0)
-	if len(cmd.text) > 0 {
-		data := []byte(cmd.text)
-		l = context.File.Insert(data, q0)
-		if l != int64(len(data)) {
-			return fmt.Errorf("Wrong number of inserted characters!")
+		if lineAddr[1] <= p {
+			break
+		}
+		end = lineAddr[1]
+		nl := lineAddr[1] - 1
+		data, err := ioutil.ReadAll(context.File.Reader(nl, nl+1))
+		if err != nil {
+			return err
+		}
+		if string(data) != "\n" {
+			// The last line has no newline to keep its format on
+			break
+		}
+		lineAttrs := make(map[string]interface{})
+		for key, value := range attrs {
+			lineAttrs[key] = value
+		}
+		current := context.File.lineAttributes(nl)
+		for key, step := range steps {
+			n := attributeNumber(current[key]) + step
+			if n > 0 {
+				lineAttrs[key] = n
+			} else {
+				lineAttrs[key] = nil
+			}
+		}
+		err = context.File.FormatLine(nl, lineAttrs)
+		if err != nil {
+			return err
 		}
 	}
+	applied := context.File.transform(start, true)
+	context.File.Select(applied, applied+end-start)
 	return nil
 }
 
 func mCmd(context innerContext, cmd Cmd) error {
-	addr2, err := cmdAddress(cmd.mtaddr, context, 0)
+	addr2, f2, err := cmdAddress(cmd.mtaddr, context, 0)
 	if err != nil {
 		return err
 	}
@@ -477,22 +1044,34 @@ func mCmd(context innerContext, cmd Cmd) error {
 	if q1 <= q0 {
 		return nil
 	}
-	if q0 == addr2[0] && q1 == addr2[1] {
+	if f2 == context.File && q0 == addr2[0] && q1 == addr2[1] {
 		return nil
 	}
-	if q1 <= addr2[0] || q0 >= addr2[1] {
-		reader := context.File.Reader(q0, q1)
-		data := make([]byte, q1-q0)
-		_, err := io.ReadFull(reader, data)
+	if f2 != context.File || q1 <= addr2[0] || q0 >= addr2[1] {
+		data, err := context.File.Text(q0, q1)
 		if err != nil {
 			return err
 		}
-		context.File.Delete(q0, q1)
-		context.File.Insert(data, addr2[1])
-	} else {
-		return fmt.Errorf("Move overlaps itself!")
+		// Changes must be made in order, so moving text backwards copies
+		// it before deleting it.
+		if f2 == context.File && addr2[1] <= q0 {
+			_, err = f2.InsertDelta(data, addr2[1])
+			if err != nil {
+				return err
+			}
+			d0, d1 := f2.Dot()
+			_, err = context.File.Delete(q0, q1)
+			f2.Select(d0, d1)
+			return err
+		}
+		_, err = context.File.Delete(q0, q1)
+		if err != nil {
+			return err
+		}
+		_, err = f2.InsertDelta(data, addr2[1])
+		return err
 	}
-	return nil
+	return fmt.Errorf("Move overlaps itself!")
 }
 
 func sCmd(context innerContext, cmd Cmd) error {
@@ -538,7 +1117,28 @@ func sCmd(context innerContext, cmd Cmd) error {
 		rangesets = append(rangesets, rangeset)
 	}
 	for _, rangeset := range rangesets {
+		// Matched text is copied with its formatting and embeds, the rest
+		// of the replacement takes on the formatting of the match.
+		d := delta.New(nil)
 		buf := make([]rune, 0)
+		flush := func() {
+			if len(buf) > 0 {
+				d = d.Concat(formattedText(context, []byte(string(buf)), rangeset[0].q0, rangeset[0].q1))
+				buf = buf[:0]
+			}
+		}
+		copyRange := func(r textRange) error {
+			flush()
+			if r.q1 <= r.q0 {
+				return nil
+			}
+			data, err := context.File.Text(r.q0, r.q1)
+			if err != nil {
+				return err
+			}
+			d = d.Concat(data)
+			return nil
+		}
 		text := []rune(cmd.text)
 		for i := 0; i < len(text); i++ {
 			if text[i] == '\\' && i < len(text)-1 {
@@ -549,35 +1149,24 @@ func sCmd(context innerContext, cmd Cmd) error {
 					if j >= len(rangeset) {
 						return fmt.Errorf("Invalid replacement offset!")
 					}
-					_, err = reader.Seek(rangeset[j].q0-q0, io.SeekStart)
+					err = copyRange(rangeset[j])
 					if err != nil {
 						return err
 					}
-					data := make([]byte, rangeset[j].q1-rangeset[j].q0)
-					_, err = io.ReadAtLeast(reader, data, len(data))
-					if err != nil {
-						return nil
-					}
-					buf = append(buf, []rune(string(data))...)
 				} else {
 					buf = append(buf, ch)
 				}
 			} else if text[i] != '&' {
 				buf = append(buf, text[i])
 			} else {
-				_, err = reader.Seek(rangeset[0].q0-q0, io.SeekStart)
+				err = copyRange(rangeset[0])
 				if err != nil {
 					return err
 				}
-				data := make([]byte, rangeset[0].q1-rangeset[0].q0)
-				_, err = io.ReadAtLeast(reader, data, len(data))
-				if err != nil {
-					return nil
-				}
-				buf = append(buf, []rune(string(data))...)
 			}
 		}
-		err = replaceText(context, rangeset[0].q0, rangeset[0].q1, []byte(string(buf)))
+		flush()
+		err = replaceDelta(context, rangeset[0].q0, rangeset[0].q1, *d)
 		if err != nil {
 			return err
 		}
@@ -586,7 +1175,7 @@ func sCmd(context innerContext, cmd Cmd) error {
 }
 
 func tCmd(context innerContext, cmd Cmd) error {
-	addr2, err := cmdAddress(cmd.mtaddr, context, 0)
+	addr2, f2, err := cmdAddress(cmd.mtaddr, context, 0)
 	if err != nil {
 		return err
 	}
@@ -594,21 +1183,55 @@ func tCmd(context innerContext, cmd Cmd) error {
 	if q1 <= q0 {
 		return nil
 	}
-	reader := context.File.Reader(q0, q1)
-	data := make([]byte, q1-q0)
-	_, err = io.ReadFull(reader, data)
+	data, err := context.File.Text(q0, q1)
 	if err != nil {
 		return err
 	}
-	context.File.Insert(data, addr2[1])
+	_, err = f2.InsertDelta(data, addr2[1])
+	return err
+}
+
+func nCmd(context innerContext, cmd Cmd) error {
+	for _, f := range context.files.files {
+		err := printMenuLine(context, f)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func uCmd(context innerContext, cmd Cmd) error {
+	if context.File == nil {
+		return fmt.Errorf("No current file!")
+	}
+	return context.File.Undo(cmd.num)
+}
+
 func pCmd(context innerContext, cmd Cmd) error {
+	format, err := parsePrintFormat(strings.TrimSpace(cmd.text), context.PrintFormat)
+	if err != nil {
+		return err
+	}
 	q0, q1 := context.File.Dot()
 	if context.Printer != nil {
-		reader := context.File.Reader(q0, q1)
-		_, err := io.Copy(context.Printer, reader)
+		d, rich := context.File.Contents(q0, q1)
+		if !rich && format == PrintText {
+			_, err = io.Copy(context.Printer, context.File.Reader(q0, q1))
+		} else {
+			if !rich {
+				d, err = context.File.Text(q0, q1)
+				if err != nil {
+					return err
+				}
+			}
+			var text string
+			text, err = formatDelta(d, format)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(context.Printer, text)
+		}
 		if err != nil {
 			return err
 		}
@@ -617,14 +1240,70 @@ func pCmd(context innerContext, cmd Cmd) error {
 	return nil
 }
 
+func wCmd(context innerContext, cmd Cmd) error {
+	name, err := fileName(context, cmd)
+	if err != nil {
+		return err
+	}
+	q0, q1 := context.File.Dot()
+	reader := context.File.Reader(q0, q1)
+	data := make([]byte, q1-q0)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return err
+	}
+	err = context.FS.WriteFile(name, data, 0666)
+	if err != nil {
+		return err
+	}
+	if q0 == 0 && q1 == context.File.Len() {
+		context.File.modified = false
+	}
+	if context.Printer != nil {
+		n := context.File.unitPosition(q1) - context.File.unitPosition(q0)
+		_, err = fmt.Fprintf(context.Printer, "%s: #%d\n", name, n)
+	}
+	return err
+}
+
 func xCmd(context innerContext, cmd Cmd) error {
-	if cmd.re != "" {
+	if cmd.attr != "" {
+		return attributeLooper(context, cmd, byte(cmd.cmdc) == 'x')
+	} else if cmd.re != "" {
 		return looper(context, cmd, cmd.cmdc == uint16('x'))
 	} else {
 		return lineLooper(context, cmd)
 	}
 }
 
+func xFilesCmd(context innerContext, cmd Cmd) error {
+	files := context.files.files
+	if cmd.re != "" {
+		var err error
+		files, err = context.files.match(cmd.re)
+		if err != nil {
+			return err
+		}
+	}
+	matched := make(map[*innerFile]bool)
+	for _, f := range files {
+		matched[f] = true
+	}
+	isX := cmd.cmdc == uint16('X')
+	for _, f := range append([]*innerFile(nil), context.files.files...) {
+		if matched[f] != isX {
+			continue
+		}
+		context.File = f
+		context.files.current = f
+		err := cmdExec(*cmd.cmd, context)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func eqCmd(context innerContext, cmd Cmd) error {
 	var mode int
 	switch len(cmd.text) {
@@ -646,6 +1325,44 @@ func eqCmd(context innerContext, cmd Cmd) error {
 	return printPosn(context, mode)
 }
 
+// pipeCmd runs the external command of <, >, | and !. Like sam, < and |
+// replace dot with the command output, > and | feed dot to its input.
+func pipeCmd(context innerContext, cmd Cmd) error {
+	command := strings.TrimSpace(cmd.text)
+	if command == "" {
+		return fmt.Errorf("No command to run!")
+	}
+	printer := context.Printer
+	if printer == nil {
+		printer = ioutil.Discard
+	}
+	var q0, q1 int64
+	var stdin io.Reader
+	if cmd.cmdc != uint16('!') {
+		q0, q1 = context.File.Dot()
+		if cmd.cmdc != uint16('<') {
+			stdin = context.File.Reader(q0, q1)
+		}
+	}
+	stdout := printer
+	output := bytes.NewBuffer(nil)
+	if cmd.cmdc == uint16('<') || cmd.cmdc == uint16('|') {
+		stdout = output
+	}
+	err := context.Runner.Run(command, stdin, stdout, printer)
+	if err != nil {
+		return fmt.Errorf("Command %s failed: %v", command, err)
+	}
+	switch cmd.cmdc {
+	case '<', '|':
+		return replaceText(context, q0, q1, output.Bytes())
+	case '!':
+		_, err = io.WriteString(printer, "!\n")
+		return err
+	}
+	return nil
+}
+
 func looper(context innerContext, cmd Cmd, isX bool) error {
 	re, err := compileRegexp(cmd.re)
 	if err != nil {
@@ -695,6 +1412,30 @@ func looper(context innerContext, cmd Cmd, isX bool) error {
 	return loopCmd(context, *cmd.cmd, ranges)
 }
 
+// attributeLooper runs x and y over the runs of text carrying an attribute.
+func attributeLooper(context innerContext, cmd Cmd, isX bool) error {
+	q0, q1 := context.File.Dot()
+	runs, err := attributeRuns(context, cmd.attr, cmd.re, q0, q1)
+	if err != nil {
+		return err
+	}
+	if isX {
+		return loopCmd(context, *cmd.cmd, runs)
+	}
+	ranges := make([]textRange, 0)
+	op := q0
+	for _, r := range runs {
+		if r.q0 > op {
+			ranges = append(ranges, textRange{q0: op, q1: r.q0})
+		}
+		op = r.q1
+	}
+	if op < q1 {
+		ranges = append(ranges, textRange{q0: op, q1: q1})
+	}
+	return loopCmd(context, *cmd.cmd, ranges)
+}
+
 func lineLooper(context innerContext, cmd Cmd) error {
 	q0, q1 := context.File.Dot()
 	a3 := textRange{
@@ -747,28 +1488,127 @@ func loopCmd(context innerContext, cmd Cmd, ranges []textRange) error {
 	return nil
 }
 
-func regexpSearch(reStr string, context innerContext, start int64, end int64, sign int) ([]int64, error) {
+// regexpSearch looks for the next match of reStr after start, or the last
+// match before start when sign is negative. Like sam, when nothing is found
+// in that direction the search wraps around to the other end of the file,
+// the second return value tells if this happened.
+func regexpSearch(reStr string, context innerContext, start int64, sign int) ([]int64, bool, error) {
 	re, err := compileRegexp(reStr)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	reader := bufio.NewReader(context.File.Reader(start, end))
-	location := re.FindReaderIndex(reader)
-	if location == nil {
-		return nil, nil
+	l := context.File.Len()
+	if sign >= 0 {
+		location, err := regexpFind(re, context, start, l)
+		if err != nil || location != nil || start == 0 {
+			return location, false, err
+		}
+		location, err = regexpFind(re, context, 0, l)
+		return location, location != nil, err
 	}
-	if sign < 0 {
-		// Iterator more searches to look for last match
-		for {
-			start = int64(location[1]) + 1
-			reader = bufio.NewReader(context.File.Reader(start, end))
-			nextLocation := re.FindReaderIndex(reader)
-			if nextLocation == nil {
-				break
+	location, err := regexpFindLast(re, context, 0, start)
+	if err != nil || location != nil || start == l {
+		return location, false, err
+	}
+	location, err = regexpFindLast(re, context, 0, l)
+	return location, location != nil, err
+}
+
+// attributeSearch is regexpSearch for @attr/re/ addresses, finding the next
+// or previous run of text whose attribute attr has a value matching reStr.
+func attributeSearch(attr, reStr string, context innerContext, start int64, sign int) ([]int64, bool, error) {
+	l := context.File.Len()
+	if sign >= 0 {
+		runs, err := attributeRuns(context, attr, reStr, start, l)
+		if err != nil || len(runs) > 0 || start == 0 {
+			return firstRun(runs), false, err
+		}
+		runs, err = attributeRuns(context, attr, reStr, 0, l)
+		return firstRun(runs), len(runs) > 0, err
+	}
+	runs, err := attributeRuns(context, attr, reStr, 0, start)
+	if err != nil || len(runs) > 0 || start == l {
+		return lastRun(runs), false, err
+	}
+	runs, err = attributeRuns(context, attr, reStr, 0, l)
+	return lastRun(runs), len(runs) > 0, err
+}
+
+// attributeRuns returns the runs of text within q0, q1 carrying attribute
+// attr, with a value matching reStr unless it is empty.
+func attributeRuns(context innerContext, attr, reStr string, q0, q1 int64) ([]textRange, error) {
+	var re *regexp.Regexp
+	if reStr != "" {
+		var err error
+		re, err = compileRegexp(reStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return context.File.matchRuns(q0, q1, func(attrs map[string]interface{}) bool {
+		value, ok := attrs[attr]
+		if !ok || value == nil {
+			return false
+		}
+		if re == nil {
+			return true
+		}
+		s, ok := value.(string)
+		if !ok {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return false
 			}
-			location = nextLocation
+			s = string(data)
 		}
+		return re.MatchString(s)
+	})
+}
+
+func firstRun(runs []textRange) []int64 {
+	if len(runs) == 0 {
+		return nil
 	}
+	return []int64{runs[0].q0, runs[0].q1}
+}
+
+func lastRun(runs []textRange) []int64 {
+	if len(runs) == 0 {
+		return nil
+	}
+	r := runs[len(runs)-1]
+	return []int64{r.q0, r.q1}
+}
+
+func regexpFind(re *regexp.Regexp, context innerContext, start int64, end int64) ([]int64, error) {
+	reader := context.File.Reader(start, end)
+	if reader == nil {
+		return nil, fmt.Errorf("Address out of range!")
+	}
+	location := re.FindReaderIndex(bufio.NewReader(reader))
+	if location == nil {
+		return nil, nil
+	}
+	return []int64{int64(location[0]) + start, int64(location[1]) + start}, nil
+}
+
+// regexpFindLast returns the last match within start, end. The range is
+// matched in one go, so ^ and \b only match where the text allows them
+// rather than after every earlier match.
+func regexpFindLast(re *regexp.Regexp, context innerContext, start int64, end int64) ([]int64, error) {
+	reader := context.File.Reader(start, end)
+	if reader == nil {
+		return nil, fmt.Errorf("Address out of range!")
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	locations := re.FindAllIndex(data, -1)
+	if len(locations) == 0 {
+		return nil, nil
+	}
+	location := locations[len(locations)-1]
 	return []int64{int64(location[0]) + start, int64(location[1]) + start}, nil
 }
 
@@ -894,19 +1734,132 @@ func extractLineAddress(context innerContext, lineNumber int64, sign int, curren
 	return result, nil
 }
 
+// fileName returns the file name given to r, w or e, falling back to the
+// name of the current file like sam does.
+func fileName(context innerContext, cmd Cmd) (string, error) {
+	name := strings.TrimSpace(cmd.text)
+	if name == "" {
+		name = context.File.name
+	}
+	if name == "" {
+		return "", fmt.Errorf("No file name!")
+	}
+	return name, nil
+}
+
 func compileRegexp(reStr string) (*regexp.Regexp, error) {
 	return regexp.Compile(fmt.Sprintf("(?m)%s", reStr))
 }
 
+// replaceText replaces the range q0, q1 with data, which takes on the
+// formatting of the text it replaces.
 func replaceText(context innerContext, q0 int64, q1 int64, data []byte) error {
+	return replaceDelta(context, q0, q1, formattedText(context, data, q0, q1))
+}
+
+// replaceDelta deletes the range q0, q1 and inserts d at its end like sam
+// does, so the two changes stay in sequence.
+func replaceDelta(context innerContext, q0 int64, q1 int64, d delta.Delta) error {
 	if q1 > q0 {
-		context.File.Delete(q0, q1)
+		_, err := context.File.Delete(q0, q1)
+		if err != nil {
+			return err
+		}
 	}
-	l := int64(0)
-	if len(data) > 0 {
-		l = context.File.Insert(data, q0)
-		if l != int64(len(data)) {
-			return fmt.Errorf("Wrong number of inserted characters!")
+	l, err := context.File.InsertDelta(d, q1)
+	if err != nil {
+		return err
+	}
+	if l != textLen(d) {
+		return fmt.Errorf("Wrong number of inserted characters!")
+	}
+	return nil
+}
+
+// formattedText turns data replacing the range q0, q1 into a delta whose
+// text keeps the inline attributes found there. Newlines are inserted
+// without attributes since Quill keeps line formats on them.
+func formattedText(context innerContext, data []byte, q0, q1 int64) delta.Delta {
+	attrs := context.File.inlineAttributes(q0, q1)
+	d := delta.New(nil)
+	lines := strings.SplitAfter(string(data), "\n")
+	for _, line := range lines {
+		if strings.HasSuffix(line, "\n") {
+			d.Insert(line[:len(line)-1], attrs)
+			d.Insert("\n", nil)
+		} else {
+			d.Insert(line, attrs)
+		}
+	}
+	return *d
+}
+
+// parseAttributes reads the arguments of fmt and lfmt: key=value sets an
+// attribute, with value parsed as JSON when it can be and taken as a string
+// otherwise, a bare key sets it to true and -key removes it. key+=n and
+// key-=n are added to steps, which is nil when they are not allowed.
+func parseAttributes(text string, steps map[string]float64) (map[string]interface{}, error) {
+	attrs := make(map[string]interface{})
+	for _, field := range strings.Fields(text) {
+		if strings.HasPrefix(field, "-") {
+			attrs[field[1:]] = nil
+			continue
+		}
+		i := strings.IndexByte(field, '=')
+		if i < 0 {
+			attrs[field] = true
+			continue
+		}
+		if i > 0 && (field[i-1] == '+' || field[i-1] == '-') {
+			if steps == nil {
+				return nil, fmt.Errorf("Steps only apply to line formats!")
+			}
+			n, err := strconv.ParseFloat(field[i+1:], 64)
+			if err != nil {
+				return nil, fmt.Errorf("Bad step %s", field)
+			}
+			if field[i-1] == '-' {
+				n = -n
+			}
+			steps[field[:i-1]] += n
+			continue
+		}
+		var value interface{}
+		if json.Unmarshal([]byte(field[i+1:]), &value) != nil {
+			value = field[i+1:]
+		}
+		attrs[field[:i]] = value
+	}
+	if len(attrs) == 0 && len(steps) == 0 {
+		return nil, fmt.Errorf("No attributes!")
+	}
+	_, emptyAttr := attrs[""]
+	_, emptyStep := steps[""]
+	if emptyAttr || emptyStep {
+		return nil, fmt.Errorf("Bad attribute name!")
+	}
+	return attrs, nil
+}
+
+// attributeNumber returns the numeric value of a format like header, which
+// is 0 when the format is not set.
+func attributeNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+func printMenuLine(context innerContext, f *innerFile) error {
+	if context.Printer != nil {
+		_, err := io.WriteString(context.Printer, context.files.menuLine(f))
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -919,9 +1872,9 @@ func printPosn(context innerContext, mode int) error {
 	case posnChars:
 		var secondText string
 		if q1 != q0 {
-			secondText = fmt.Sprintf(",#%d", q1)
+			secondText = fmt.Sprintf(",#%d", context.File.unitPosition(q1))
 		}
-		text = fmt.Sprintf("#%d%s\n", q0, secondText)
+		text = fmt.Sprintf("#%d%s\n", context.File.unitPosition(q0), secondText)
 	case posnLine:
 		l1, _, err := lineEndingCount(context, 0, q0)
 		if err != nil {
@@ -991,5 +1944,5 @@ func lineEndingCount(context innerContext, q0, q1 int64) (int64, int64, error) {
 		}
 		q0 += 1
 	}
-	return nl, q0 - start, nil
+	return nl, context.File.unitPosition(q0) - context.File.unitPosition(start), nil
 }
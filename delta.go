@@ -7,20 +7,94 @@ import (
 	"github.com/fmpwizard/go-quilljs-delta/delta"
 )
 
+// DeltaFile is a File holding a Quill document in memory. Its positions
+// count UTF-16 code units like Quill does unless another Unit is given to
+// NewDeltaFileUnit, while the delta package itself counts runes.
 type DeltaFile struct {
 	delta.Delta
+	unit       Unit
+	base       delta.Delta // the document changes apply to
 	changes    delta.Delta
 	start, end int64
+	undo, redo []historyEntry
+	cache      deltaCache
+}
+
+// deltaCache keeps the text of a DeltaFile with its indexes until the
+// document changes, so reading it does not rebuild them every time. A
+// document changed through the DeltaFile or given new Ops is noticed, one
+// whose Ops are edited in place is not.
+type deltaCache struct {
+	ops          []delta.Op // the Ops the cache was built from
+	data         []byte
+	units, runes *unitIndex
+}
+
+// cached returns the text of the document and its indexes, building them
+// again only when the Ops changed since the last call.
+func (e *DeltaFile) cached() *deltaCache {
+	c := &e.cache
+	if c.data != nil && len(c.ops) == len(e.Ops) && (len(e.Ops) == 0 || &c.ops[0] == &e.Ops[0]) {
+		return c
+	}
+	c.ops = e.Ops
+	c.data = e.Bytes()
+	c.units, _ = newUnitIndex(bytes.NewReader(c.data), e.unit)
+	c.runes, _ = newUnitIndex(bytes.NewReader(c.data), Rune)
+	return c
+}
+
+// historyEntry keeps a composed delta together with its inverse, which is
+// computed from the document before the change so attributes and embeds
+// come back exactly on undo.
+type historyEntry struct {
+	change  delta.Delta
+	inverse delta.Delta
 }
 
 func NewDeltaFile(d delta.Delta) *DeltaFile {
+	return NewDeltaFileUnit(d, UTF16)
+}
+
+func NewDeltaFileUnit(d delta.Delta, unit Unit) *DeltaFile {
 	return &DeltaFile{
 		Delta: d,
+		unit:  unit,
 	}
 }
 
+func (e *DeltaFile) Unit() Unit {
+	return e.unit
+}
+
+// Changes returns all deltas composed so far as a single one.
 func (e *DeltaFile) Changes() delta.Delta {
-	return e.changes
+	base := DeltaFile{
+		Delta: e.base,
+		unit:  e.unit,
+	}
+	_, fromRunes := base.runeMaps()
+	return convertDelta(e.changes, fromRunes)
+}
+
+// runeMaps returns functions mapping positions of the file to positions of
+// the delta package and back.
+func (e *DeltaFile) runeMaps() (toRunes, fromRunes func(p int64) int64) {
+	if e.unit == Rune {
+		same := func(p int64) int64 {
+			return p
+		}
+		return same, same
+	}
+	c := e.cached()
+	units, runes := c.units, c.runes
+	toRunes = func(p int64) int64 {
+		return runes.fromByte(units.toByte(p))
+	}
+	fromRunes = func(p int64) int64 {
+		return units.fromByte(runes.toByte(p))
+	}
+	return
 }
 
 func (e *DeltaFile) Select(start, end int64) {
@@ -35,7 +109,18 @@ func (e *DeltaFile) Dot() (start, end int64) {
 }
 
 func (e *DeltaFile) Len() (int64, error) {
-	return int64(e.Length()), nil
+	if e.unit == Rune {
+		return int64(e.Length()), nil
+	}
+	l := int64(0)
+	for _, op := range e.Ops {
+		if op.Insert != nil {
+			l += unitLen(string(op.Insert), e.unit)
+		} else if op.InsertEmbed != nil {
+			l += 1
+		}
+	}
+	return l, nil
 }
 
 func (e *DeltaFile) Reader(start, end int64) io.ReadSeeker {
@@ -49,13 +134,85 @@ func (e *DeltaFile) Reader(start, end int64) io.ReadSeeker {
 	if end > l {
 		end = l
 	}
-	return bytes.NewReader(e.Bytes()[start:end])
+	c := e.cached()
+	return bytes.NewReader(c.data[c.units.toByte(start):c.units.toByte(end)])
+}
+
+func (e *DeltaFile) Contents(start, end int64) delta.Delta {
+	toRunes, _ := e.runeMaps()
+	return *e.Delta.Slice(int(toRunes(start)), int(toRunes(end)))
 }
 
 func (e *DeltaFile) Compose(d delta.Delta) error {
+	if len(d.Ops) == 0 {
+		return nil
+	}
+	toRunes, _ := e.runeMaps()
+	d = convertDelta(d, toRunes)
+	e.undo = append(e.undo, historyEntry{
+		change:  d,
+		inverse: *d.Invert(&e.Delta),
+	})
+	e.redo = nil
+	e.apply(d)
+	return nil
+}
+
+// Undo reverts the last composed delta not undone yet, it returns false when
+// there is nothing left to undo.
+func (e *DeltaFile) Undo() bool {
+	if len(e.undo) == 0 {
+		return false
+	}
+	entry := e.undo[len(e.undo)-1]
+	e.undo = e.undo[:len(e.undo)-1]
+	e.redo = append(e.redo, entry)
+	e.apply(entry.inverse)
+	return true
+}
+
+// Redo composes again the last delta reverted by Undo, it returns false when
+// there is nothing to redo.
+func (e *DeltaFile) Redo() bool {
+	if len(e.redo) == 0 {
+		return false
+	}
+	entry := e.redo[len(e.redo)-1]
+	e.redo = e.redo[:len(e.redo)-1]
+	e.undo = append(e.undo, entry)
+	e.apply(entry.change)
+	return true
+}
+
+func (e *DeltaFile) apply(d delta.Delta) {
+	if len(e.changes.Ops) == 0 {
+		e.base = e.Delta
+	}
 	e.Delta = *e.Delta.Compose(d)
 	e.changes = *e.changes.Compose(d)
-	return nil
+	e.cache = deltaCache{}
+	_, fromRunes := e.runeMaps()
+	q0, q1 := changedRange(d)
+	e.Select(fromRunes(q0), fromRunes(q1))
+}
+
+// changedRange returns the range of a document d touches, in coordinates of
+// the document after d is applied.
+func changedRange(d delta.Delta) (int64, int64) {
+	q0, q1 := int64(0), int64(0)
+	for i, op := range d.Ops {
+		if op.Delete != nil {
+			continue
+		}
+		if i == 0 && op.Retain != nil && op.Attributes == nil {
+			q0 = int64(*op.Retain)
+		}
+		q1 += int64(op.Length())
+	}
+	if q1 < q0 {
+		q1 = q0
+	}
+	return q0, q1
 }
 
 func (e *DeltaFile) Bytes() []byte {
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
@@ -50,9 +51,10 @@ func TestDelta(t *testing.T) {
 }
 
 type testDelta struct {
-	content    delta.Delta
-	changes    delta.Delta
-	start, end int64
+	content            delta.Delta
+	changes            delta.Delta
+	start, end         int64
+	markStart, markEnd int64
 }
 
 func newTestDelta(d delta.Delta) *testDelta {
@@ -76,6 +78,17 @@ func (t *testDelta) Dot() (start, end int64) {
 	return
 }
 
+func (t *testDelta) SetMark(start, end int64) {
+	t.markStart = start
+	t.markEnd = end
+}
+
+func (t *testDelta) Mark() (start, end int64) {
+	start = t.markStart
+	end = t.markEnd
+	return
+}
+
 func (t *testDelta) Len() (int64, error) {
 	return (&DeltaFile{
 		Delta: *t.content.Compose(t.changes),
@@ -396,6 +409,26 @@ the method in the madness that is the vi command structure.
 			},
 		},
 	},
+	{
+		source: "aa\nbb\n",
+		runs: []testCaseRun{
+			{
+				command: "$?^a?=#",
+				result:  "aa\nbb\n",
+				print:   "#0,#1\n",
+			},
+			{
+				command: "$?^b?=#",
+				result:  "aa\nbb\n",
+				print:   "#3,#4\n",
+			},
+			{
+				command: "$?\\bb?=#",
+				result:  "aa\nbb\n",
+				print:   "#3,#4\n",
+			},
+		},
+	},
 	{
 		source: DefaultSource,
 		runs: []testCaseRun{
@@ -423,6 +456,68 @@ the method in the madness that is the vi command structure.
 			},
 		},
 	},
+	{
+		source: DefaultSource,
+		runs: []testCaseRun{
+			{
+				command: "/haphazard/k",
+				result:  DefaultSource,
+				print:   "",
+			},
+			{
+				command: "0i/Preface: /",
+				result:  "Preface: " + DefaultSource,
+				print:   "",
+			},
+			{
+				command: "'p",
+				result:  "Preface: " + DefaultSource,
+				print:   "haphazard",
+			},
+			{
+				command: "'c/careless/",
+				result: `Preface: This manual is organized in a rather careless manner. The first
+several sections were written hastily in an attempt to provide a
+general introduction to the commands in Emacs and to try to show
+the method in the madness that is the Emacs command structure.
+`,
+				print: "",
+			},
+		},
+	},
+	{
+		source: DefaultSource,
+		runs: []testCaseRun{
+			{
+				command: "/rather /m0",
+				result: `rather This manual is organized in a haphazard manner. The first
+several sections were written hastily in an attempt to provide a
+general introduction to the commands in Emacs and to try to show
+the method in the madness that is the Emacs command structure.
+`,
+				print: "",
+			},
+			{
+				command: "p",
+				result: `rather This manual is organized in a haphazard manner. The first
+several sections were written hastily in an attempt to provide a
+general introduction to the commands in Emacs and to try to show
+the method in the madness that is the Emacs command structure.
+`,
+				print: "rather ",
+			},
+		},
+	},
+	{
+		source: "ab\n",
+		runs: []testCaseRun{
+			{
+				command: "/b/{\ni/x/\na/y/\na/z/\n}",
+				result:  "axbyz\n",
+				print:   "",
+			},
+		},
+	},
 }
 
 func TestMultipleCases(t *testing.T) {
@@ -453,3 +548,614 @@ func TestMultipleCases(t *testing.T) {
 		}
 	}
 }
+
+func TestSearchWrap(t *testing.T) {
+	for _, command := range []string{"$/manual/p", "0?manual?p"} {
+		f := newTestDelta(*delta.New(nil).Insert(DefaultSource, nil))
+		buf := bytes.NewBuffer(nil)
+		warnings := make([]Warning, 0)
+		cmd, err := Compile(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmd.Run(Context{
+			File:    f,
+			Printer: buf,
+			Warn: func(w Warning) {
+				warnings = append(warnings, w)
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error running command %s: %v", command, err)
+		}
+		if buf.String() != "manual" {
+			t.Fatalf("Invalid print data for command %s: %s", command, buf.String())
+		}
+		if !reflect.DeepEqual(warnings, []Warning{SearchWrapped}) {
+			t.Fatalf("Invalid warnings for command %s: %v", command, warnings)
+		}
+	}
+}
+
+type testRunner struct {
+	dir      string
+	commands []string
+}
+
+func (r *testRunner) Run(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	r.commands = append(r.commands, command)
+	if command != "upper" {
+		_, err := io.WriteString(stdout, r.dir+"$ "+command+"\n")
+		return err
+	}
+	data, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(bytes.ToUpper(data))
+	return err
+}
+
+func (r *testRunner) Chdir(dir string) error {
+	r.dir = dir
+	return nil
+}
+
+func TestPipeCommands(t *testing.T) {
+	f := newTestDelta(*delta.New(nil).Insert(DefaultSource, nil))
+	runner := &testRunner{}
+	buf := bytes.NewBuffer(nil)
+	ctx := Context{
+		File:    f,
+		Printer: buf,
+		Runner:  runner,
+	}
+	for _, command := range []string{"/haphazard/|upper", "cd /tmp", "!ls", "/first/<date", "/sections/>wc"} {
+		cmd, err := Compile(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmd.Run(ctx)
+		if err != nil {
+			t.Fatalf("Error running command %s: %v", command, err)
+		}
+	}
+	expectedContent := `This manual is organized in a rather HAPHAZARD manner. The /tmp$ date
+
+several sections were written hastily in an attempt to provide a
+general introduction to the commands in Emacs and to try to show
+the method in the madness that is the Emacs command structure.
+`
+	if actualContent := f.String(); actualContent != expectedContent {
+		t.Fatalf("Invalid result: expected: \"%s\", actual: \"%s\"", expectedContent, actualContent)
+	}
+	if printed := buf.String(); printed != "/tmp$ ls\n!\n/tmp$ wc\n" {
+		t.Fatalf("Invalid print data: \"%s\"", printed)
+	}
+	if !reflect.DeepEqual(runner.commands, []string{"upper", "ls", "date", "wc"}) {
+		t.Fatalf("Invalid commands: %v", runner.commands)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	content := *delta.New(nil).Insert("Some ", nil).
+		Insert("bold", map[string]interface{}{"bold": true}).
+		InsertEmbed(delta.Embed{
+			Key:   "image",
+			Value: "image-uri",
+		}, nil).
+		Insert(" text\n", nil)
+	e := NewDeltaFile(*delta.New(nil).Concat(content))
+	for _, command := range []string{"/bold./c/plain/", ",s/text/words/"} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if actual := string(e.Bytes()); actual != "Some plain words\n" {
+		t.Fatalf("Invalid content: \"%s\"", actual)
+	}
+	err := run("u2", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e.Delta, content) {
+		t.Fatalf("Invalid undo, expected: %s, actual: %s",
+			debugDeltaString(t, content), debugDeltaString(t, e.Delta))
+	}
+	if e.Undo() {
+		t.Fatalf("Undo should have nothing left to undo")
+	}
+	err = run("u-1", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := string(e.Bytes()); actual != "Some plain text\n" {
+		t.Fatalf("Invalid content after redo: \"%s\"", actual)
+	}
+	q0, q1 := e.Dot()
+	if q0 != 5 || q1 != 10 {
+		t.Fatalf("Invalid dot after redo: (%d, %d)", q0, q1)
+	}
+	if !e.Redo() || e.Redo() {
+		t.Fatalf("Invalid redo history")
+	}
+}
+
+func TestChangesNotInSequence(t *testing.T) {
+	cmd, err := Compile("/manual/{\nc/guide/\n-#2,.+#2d\n}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newTestDelta(*delta.New(nil).Insert(DefaultSource, nil))
+	err = cmd.Run(Context{
+		File: f,
+	})
+	expectedErr := "Changes not in sequence: #3,#13 comes before the earlier change at #5,#11"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("Invalid error, expected: %s, actual: %v", expectedErr, err)
+	}
+	if f.String() != DefaultSource {
+		t.Fatalf("Content changed after a failed command: \"%s\"", f.String())
+	}
+	err = cmd.Run(Context{
+		File:    f,
+		Lenient: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedContent := "This organized in a rather haphazard manner. The first\n"
+	if actualContent := f.String()[:len(expectedContent)]; actualContent != expectedContent {
+		t.Fatalf("Invalid lenient result: \"%s\"", actualContent)
+	}
+}
+
+func TestChangesNotInSequenceUnits(t *testing.T) {
+	f := NewDeltaFile(*delta.New(nil).Insert("日本語 abc def\n", nil))
+	err := run("/def/{\nc/DEF/\n-#4,-#1d\n}", f)
+	expectedErr := "Changes not in sequence: #4,#7 comes before the earlier change at #8,#11"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("Invalid error, expected: %s, actual: %v", expectedErr, err)
+	}
+}
+
+func TestFormattingPreserved(t *testing.T) {
+	bold := map[string]interface{}{"bold": true}
+	link := map[string]interface{}{"italic": true, "link": "https://example.com"}
+	e := NewDeltaFile(*delta.New(nil).Insert("Some ", nil).
+		Insert("teh", bold).
+		Insert(" and ", nil).
+		Insert("teh", link).
+		Insert("\n", map[string]interface{}{"header": 1}))
+	for _, command := range []string{",s/teh/the/g", "/the/a/re/", "$-#1i/ end/"} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedContent := *delta.New(nil).Insert("Some ", nil).
+		Insert("there", bold).
+		Insert(" and ", nil).
+		Insert("the end", link).
+		Insert("\n", map[string]interface{}{"header": 1})
+	if !reflect.DeepEqual(e.Delta, expectedContent) {
+		t.Fatalf("Invalid content, expected: %s, actual: %s",
+			debugDeltaString(t, expectedContent), debugDeltaString(t, e.Delta))
+	}
+	expectedChange := *delta.New(nil).Retain(5, nil).Delete(3).Insert("there", bold).
+		Retain(5, nil).Delete(3).Insert("the end", link)
+	if !reflect.DeepEqual(e.Changes(), expectedChange) {
+		t.Fatalf("Invalid change, expected: %s, actual: %s",
+			debugDeltaString(t, expectedChange), debugDeltaString(t, e.Changes()))
+	}
+	italic := map[string]interface{}{"italic": true}
+	e = NewDeltaFile(*delta.New(nil).Insert("caf", nil).
+		Insert("é", italic).
+		Insert(" ", nil).
+		Insert("😀", bold).
+		Insert("\n", nil))
+	for _, command := range []string{",x/é/c/ee/", ",s/😀/X/g"} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedContent = *delta.New(nil).Insert("caf", nil).
+		Insert("ee", italic).
+		Insert(" ", nil).
+		Insert("X", bold).
+		Insert("\n", nil)
+	if !reflect.DeepEqual(e.Delta, expectedContent) {
+		t.Fatalf("Invalid content of non-ASCII text, expected: %s, actual: %s",
+			debugDeltaString(t, expectedContent), debugDeltaString(t, e.Delta))
+	}
+}
+
+func TestFormat(t *testing.T) {
+	link := map[string]interface{}{"link": "https://example.com"}
+	e := NewDeltaFile(*delta.New(nil).Insert("TODO one\n", nil).
+		Insert("see", link).
+		Insert(" TODO\n", nil))
+	for _, command := range []string{",x/TODO/ fmt bold=true color=\"#f00\"", "/see/fmt -link"} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	todo := map[string]interface{}{"bold": true, "color": "#f00"}
+	expectedContent := *delta.New(nil).Insert("TODO", todo).
+		Insert(" one\nsee ", nil).
+		Insert("TODO", todo).
+		Insert("\n", nil)
+	if !reflect.DeepEqual(e.Delta, expectedContent) {
+		t.Fatalf("Invalid content, expected: %s, actual: %s",
+			debugDeltaString(t, expectedContent), debugDeltaString(t, e.Delta))
+	}
+	expectedChange := *delta.New(nil).Retain(4, todo).Retain(5, nil).
+		Retain(3, map[string]interface{}{"link": nil}).Retain(1, nil).Retain(4, todo)
+	if !reflect.DeepEqual(e.Changes(), expectedChange) {
+		t.Fatalf("Invalid change, expected: %s, actual: %s",
+			debugDeltaString(t, expectedChange), debugDeltaString(t, e.Changes()))
+	}
+	if err := run("fmt", e); err == nil {
+		t.Fatal("Expected fmt without attributes to fail")
+	}
+	e = NewDeltaFile(*delta.New(nil).Insert("TODO one TODO\n", nil))
+	err := run(",x/TODO/ {\nfmt bold=true\nfmt italic=true\n}", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := map[string]interface{}{"bold": true, "italic": true}
+	expectedContent = *delta.New(nil).Insert("TODO", both).
+		Insert(" one ", nil).
+		Insert("TODO", both).
+		Insert("\n", nil)
+	if !reflect.DeepEqual(e.Delta, expectedContent) {
+		t.Fatalf("Invalid content of formats on the same text, expected: %s, actual: %s",
+			debugDeltaString(t, expectedContent), debugDeltaString(t, e.Delta))
+	}
+}
+
+func TestLineFormat(t *testing.T) {
+	e := NewDeltaFile(*delta.New(nil).Insert("Title\none\ntwo\n", nil))
+	for _, command := range []string{"1lfmt header=1", "/one/,/two/lfmt list=bullet", "1lfmt header+=1", "3lfmt -list"} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedContent := *delta.New(nil).Insert("Title", nil).
+		Insert("\n", map[string]interface{}{"header": float64(2)}).
+		Insert("one", nil).
+		Insert("\n", map[string]interface{}{"list": "bullet"}).
+		Insert("two\n", nil)
+	if !reflect.DeepEqual(e.Delta, expectedContent) {
+		t.Fatalf("Invalid content, expected: %s, actual: %s",
+			debugDeltaString(t, expectedContent), debugDeltaString(t, e.Delta))
+	}
+	if err := run("1lfmt header-=2", e); err != nil {
+		t.Fatal(err)
+	}
+	expectedContent = *delta.New(nil).Insert("Title\none", nil).
+		Insert("\n", map[string]interface{}{"list": "bullet"}).
+		Insert("two\n", nil)
+	if !reflect.DeepEqual(e.Delta, expectedContent) {
+		t.Fatalf("Invalid content, expected: %s, actual: %s",
+			debugDeltaString(t, expectedContent), debugDeltaString(t, e.Delta))
+	}
+	if err := run("fmt header+=1", e); err == nil {
+		t.Fatal("Expected fmt with a step to fail")
+	}
+}
+
+func TestAttributeAddress(t *testing.T) {
+	bold := map[string]interface{}{"bold": true}
+	strike := map[string]interface{}{"strike": true}
+	oldLink := map[string]interface{}{"link": "https://old.example.com/a"}
+	e := NewDeltaFile(*delta.New(nil).Insert("One ", nil).
+		Insert("two", bold).
+		Insert(" three ", nil).
+		Insert("four", strike).
+		Insert(" ", nil).
+		Insert("five", oldLink).
+		Insert(" ", nil).
+		Insert("six", map[string]interface{}{"link": "https://example.com"}).
+		Insert(" ", nil).
+		Insert("seven", strike).
+		Insert("\n", nil))
+	for _, command := range []string{
+		",xattr strike d",
+		",xattr link/old\\.example\\.com/ fmt link=https://new.example.com/a",
+		"@bold c/2/",
+	} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedContent := *delta.New(nil).Insert("One ", nil).
+		Insert("2", bold).
+		Insert(" three  ", nil).
+		Insert("five", map[string]interface{}{"link": "https://new.example.com/a"}).
+		Insert(" ", nil).
+		Insert("six", map[string]interface{}{"link": "https://example.com"}).
+		Insert(" \n", nil)
+	if !reflect.DeepEqual(e.Delta, expectedContent) {
+		t.Fatalf("Invalid content, expected: %s, actual: %s",
+			debugDeltaString(t, expectedContent), debugDeltaString(t, e.Delta))
+	}
+	var printed bytes.Buffer
+	cmd, err := Compile("$-@link p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Run(Context{
+		File:    e,
+		Printer: &printed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed.String() != "six" {
+		t.Fatalf("Invalid print, expected: \"six\", actual: \"%s\"", printed.String())
+	}
+	err = run(",yattr link d", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(e.Bytes()) != "fivesix" {
+		t.Fatalf("Invalid result, expected: \"fivesix\", actual: \"%s\"", string(e.Bytes()))
+	}
+}
+
+func TestAtDelimiter(t *testing.T) {
+	e := NewDeltaFile(*delta.New(nil).Insert("a/b c a/b\n", nil))
+	for _, command := range []string{",x@a/b@ d", ",g@c@ s@c@d@"} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(e.Bytes()) != " d \n" {
+		t.Fatalf("Invalid result, expected: \" d \\n\", actual: %q", string(e.Bytes()))
+	}
+}
+
+func TestEmbedEditing(t *testing.T) {
+	image := delta.Embed{
+		Key:   "image",
+		Value: "image-uri",
+	}
+	e := NewDeltaFile(*delta.New(nil).Insert("Code Em", nil).
+		InsertEmbed(image, nil).
+		Insert("acs\n", nil))
+	for _, command := range []string{
+		"/Em.acs/t0",
+		"$-/Em.acs/s/.*/[&]/",
+		"/Code/embed formula \"e=mc^2\"",
+	} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedContent := *delta.New(nil).Insert("Em", nil).
+		InsertEmbed(image, nil).
+		Insert("acs", nil).
+		InsertEmbed(delta.Embed{Key: "formula", Value: "e=mc^2"}, nil).
+		Insert(" [Em", nil).
+		InsertEmbed(image, nil).
+		Insert("acs]\n", nil)
+	if !reflect.DeepEqual(e.Delta, expectedContent) {
+		t.Fatalf("Invalid content, expected: %s, actual: %s",
+			debugDeltaString(t, expectedContent), debugDeltaString(t, e.Delta))
+	}
+	var printed bytes.Buffer
+	cmd, err := Compile(",p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Run(Context{
+		File:    e,
+		Printer: &printed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPrint := "Em[image: image-uri]acs[formula: e=mc^2] [Em[image: image-uri]acs]\n"
+	if printed.String() != expectedPrint {
+		t.Fatalf("Invalid print, expected: \"%s\", actual: \"%s\"", expectedPrint, printed.String())
+	}
+}
+
+func TestUnits(t *testing.T) {
+	units := []struct {
+		unit   Unit
+		change delta.Delta
+		print  string
+	}{
+		{UTF16, *delta.New(nil).Retain(6, nil).Delete(5).Insert("world", nil), "#15,#18\n"},
+		{Rune, *delta.New(nil).Retain(6, nil).Delete(5).Insert("world", nil), "#14,#17\n"},
+		{Byte, *delta.New(nil).Retain(7, nil).Delete(6).Insert("world", nil), "#18,#21\n"},
+	}
+	for _, u := range units {
+		e := NewDeltaFileUnit(*delta.New(nil).Insert("héllo wörld 😀 end\n", nil), u.unit)
+		err := run("/wörld/c/world/", e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(e.Changes(), u.change) {
+			t.Fatalf("Invalid change in %s, expected: %s, actual: %s", u.unit,
+				debugDeltaString(t, u.change), debugDeltaString(t, e.Changes()))
+		}
+		var printed bytes.Buffer
+		cmd, err := Compile("/end/=#")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmd.Run(Context{
+			File:    e,
+			Printer: &printed,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printed.String() != u.print {
+			t.Fatalf("Invalid print in %s, expected: \"%s\", actual: \"%s\"", u.unit, u.print, printed.String())
+		}
+		q0, q1 := e.Dot()
+		if fmt.Sprintf("#%d,#%d\n", q0, q1) != u.print {
+			t.Fatalf("Invalid dot in %s: (%d, %d)", u.unit, q0, q1)
+		}
+		err = run(u.print[:len(u.print)-1]+"c/fin/", e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(e.Bytes()) != "héllo world 😀 fin\n" {
+			t.Fatalf("Invalid result in %s: \"%s\"", u.unit, string(e.Bytes()))
+		}
+	}
+}
+
+func TestUnitIndexApply(t *testing.T) {
+	text := "日本語 abc 😀😀 déf\n"
+	applyCases := []struct {
+		change delta.Delta
+		result string
+	}{
+		{*delta.New(nil).Retain(6, nil).Insert("x", nil), "日本x語 abc 😀😀 déf\n"},
+		{*delta.New(nil).Retain(3, nil).Insert("x", nil), "日x本語 abc 😀😀 déf\n"},
+		{*delta.New(nil).Retain(3, nil).Delete(6), "日 abc 😀😀 déf\n"},
+		{*delta.New(nil).Retain(6, nil).Delete(3).Insert("é", nil), "日本é abc 😀😀 déf\n"},
+		{*delta.New(nil).Retain(14, nil).Delete(4).Insert("日日", nil), "日本語 abc 日日😀 déf\n"},
+		{*delta.New(nil).Delete(len(text)), ""},
+		{*delta.New(nil).Insert("ü", nil), "ü日本語 abc 😀😀 déf\n"},
+	}
+	for _, unit := range []Unit{UTF16, Rune, Byte} {
+		before, err := newUnitIndex(bytes.NewReader([]byte(text)), unit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range applyCases {
+			expected, err := newUnitIndex(bytes.NewReader([]byte(c.result)), unit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := before.apply(c.change)
+			for p := int64(0); p <= int64(len(c.result))+1; p++ {
+				if actual.fromByte(p) != expected.fromByte(p) || actual.toByte(p) != expected.toByte(p) {
+					t.Fatalf("Invalid index in %s for %q at %d: %v, expected: %v", unit, c.result, p, actual.runs, expected.runs)
+				}
+			}
+		}
+	}
+	// Runs keep a line of wide characters in a single entry.
+	index, err := newUnitIndex(bytes.NewReader(bytes.Repeat([]byte("日本語"), 1000)), UTF16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.runs) != 1 {
+		t.Fatalf("Invalid runs: %d", len(index.runs))
+	}
+}
+
+func TestPrintFormats(t *testing.T) {
+	bold := map[string]interface{}{"bold": true}
+	bullet := map[string]interface{}{"list": "bullet"}
+	e := NewDeltaFile(*delta.New(nil).Insert("Title", nil).
+		Insert("\n", map[string]interface{}{"header": 1}).
+		Insert("Some ", nil).
+		Insert("bold", bold).
+		Insert(" & ", nil).
+		Insert("link", map[string]interface{}{"link": "https://example.com"}).
+		Insert("\none", nil).
+		Insert("\n", bullet).
+		Insert("two", nil).
+		Insert("\n", bullet))
+	printCases := []struct {
+		command string
+		format  PrintFormat
+		print   string
+	}{
+		{",p html", PrintText, "<h1>Title</h1>\n" +
+			"<p>Some <strong>bold</strong> &amp; <a href=\"https://example.com\">link</a></p>\n" +
+			"<ul><li>one</li><li>two</li></ul>\n"},
+		{",p md", PrintText, "# Title\n\nSome **bold** & [link](https://example.com)\n\n- one\n- two\n"},
+		{"/bold/p delta", PrintText, "{\"ops\":[{\"insert\":\"bold\",\"attributes\":{\"bold\":true}}]}\n"},
+		{"/bold/p", PrintHTML, "<strong>bold</strong>"},
+		{"/bold/p text", PrintHTML, "bold"},
+	}
+	for _, c := range printCases {
+		var printed bytes.Buffer
+		cmd, err := Compile(c.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmd.Run(Context{
+			File:        e,
+			Printer:     &printed,
+			PrintFormat: c.format,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printed.String() != c.print {
+			t.Fatalf("Invalid print of %s, expected: \"%s\", actual: \"%s\"", c.command, c.print, printed.String())
+		}
+	}
+	if err := run("p pdf", e); err == nil {
+		t.Fatal("Expected an unknown print format to fail")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	content := *delta.New(nil).Insert("Title\n", nil).Insert("𝄞 one\n", nil)
+	e := NewDeltaFile(content)
+	schema := QuillSchema()
+	for _, test := range []struct {
+		command, err string
+	}{
+		{"/one/fmt bold link=https://example.com", ""},
+		{"1lfmt header=2", ""},
+		{"/one/fmt blink", "Format blink not allowed at #9"},
+		{"1lfmt header=7", "Format header=7 at #5: value must be a whole number from 1 to 6"},
+		{"1fmt align=center", "Format align not allowed at #0"},
+		{",fmt color=1", "Format color=1 at #0: value must be a string"},
+		{"/one/c/two/", ""},
+		{"/two/embed tweet 42", "Embed tweet not allowed at #9"},
+		{"/two/embed image https://example.com/a.png", ""},
+		{"/Title/-fmt -bold", ""},
+	} {
+		cmd, err := Compile(test.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := e.Delta
+		err = cmd.Run(Context{
+			File:   e,
+			Schema: schema,
+		})
+		if test.err == "" {
+			if err != nil {
+				t.Fatalf("%s: %v", test.command, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Fatalf("%s: expected error \"%s\", actual: %v", test.command, test.err, err)
+		}
+		if !reflect.DeepEqual(e.Delta, before) {
+			t.Fatalf("%s: content changed despite the error: %s", test.command, debugDeltaString(t, e.Delta))
+		}
+	}
+	if err := schema.Validate(e.Delta); err != nil {
+		t.Fatal(err)
+	}
+	err := schema.Validate(*delta.New(nil).Insert("a", nil).
+		Insert("\n", map[string]interface{}{"list": "numbered"}))
+	expected := "Format list=numbered at #1: value must be one of ordered, bullet, checked, unchecked"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected error \"%s\", actual: %v", expected, err)
+	}
+}
@@ -3,6 +3,7 @@ package editor
 import (
 	"bytes"
 	"fmt"
+	"strings"
 )
 
 // Corresponds to Addr in https://github.com/9fans/plan9port/blob/4650064aa757c217fa72f8819a2cf67c689bcdef/src/cmd/acme/edit.h#L16
@@ -11,7 +12,8 @@ type Addr struct {
 
 	// Either re or left can exist
 	re   string
-	left *Addr // left side of , and ;
+	left *Addr  // left side of , and ;
+	attr string // attribute name of @, re then matches its value
 
 	num  int64
 	next *Addr // right side of , and ;
@@ -20,6 +22,7 @@ type Addr struct {
 type Cmd struct {
 	addr *Addr
 	re   string
+	attr string // attribute xattr, yattr, gattr and vattr match, re then matches its value
 
 	// One of cmd, text and mtaddr can exist
 	cmd    *Cmd   // target of x, g, {, etc.
@@ -32,6 +35,10 @@ type Cmd struct {
 	cmdc uint16 // command character
 }
 
+// Commands named by more than one character are kept in cmdc as their
+// first character with 0x100 set.
+var longCmdNames = []string{"cd", "embed", "fmt", "gattr", "lfmt", "vattr", "xattr", "yattr"}
+
 type textRange struct {
 	q0 int64
 	q1 int64
@@ -63,6 +70,38 @@ func (s *cmdScanner) unread() {
 	}
 }
 
+// readString consumes str if the input continues with it.
+func (s *cmdScanner) readString(str string) bool {
+	if !strings.HasPrefix(s.c[s.i:], str) {
+		return false
+	}
+	s.i += len(str)
+	return true
+}
+
+// readAttribute reads the attribute name and optional /re/ for its value
+// following an @ address or an attribute command.
+func (s *cmdScanner) readAttribute() (string, string, error) {
+	start := s.i
+	for c, success := s.peek(); success && (c == '_' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')); c, success = s.peek() {
+		s.read()
+	}
+	name := s.c[start:s.i]
+	if name == "" {
+		return "", "", fmt.Errorf("No attribute name!")
+	}
+	if c, success := s.peek(); !success || c != '/' {
+		return name, "", nil
+	}
+	s.read()
+	re, err := s.readRegexp('/')
+	if err != nil {
+		return "", "", err
+	}
+	return name, re, nil
+}
+
 func (s *cmdScanner) readNum(processSign bool) int64 {
 	n := int64(0)
 	sign := int64(1)
@@ -286,6 +325,12 @@ func parseSimpleAddr(s *cmdScanner) (*Addr, error) {
 		if err != nil {
 			return nil, err
 		}
+	case '@':
+		addr.t, _ = s.read()
+		addr.attr, addr.re, err = s.readAttribute()
+		if err != nil {
+			return nil, err
+		}
 	case '.':
 		fallthrough
 	case '$':
@@ -325,6 +370,8 @@ func parseSimpleAddr(s *cmdScanner) (*Addr, error) {
 		case '/':
 			fallthrough
 		case '?':
+			fallthrough
+		case '@':
 			if addr.t != '+' && addr.t != '-' {
 				// Insert missing '+'
 				nap := Addr{
@@ -382,11 +429,10 @@ func innerParseCmd(s *cmdScanner, nest int) (*Cmd, error) {
 		return nil, nil
 	}
 	cmd.cmdc = uint16(c)
-	if c == 'c' {
-		nc, ns := s.peek()
-		if ns && nc == 'd' {
-			s.read()
-			cmd.cmdc = uint16('c') | 0x100 // command cd
+	for _, name := range longCmdNames {
+		if c == name[0] && s.readString(name[1:]) {
+			cmd.cmdc = uint16(c) | 0x100
+			break
 		}
 	}
 	ct := cmdLookup(cmd.cmdc)
@@ -400,7 +446,15 @@ func innerParseCmd(s *cmdScanner, nest int) (*Cmd, error) {
 		if ct.count > 0 {
 			cmd.num = s.readNum(ct.count > 1)
 		}
-		if ct.regexp {
+		if ct.regexp && cmd.cmdc&0x100 != 0 {
+			// The attribute commands take a name where the others take a
+			// delimited regexp, as any punctuation delimits one.
+			s.peekSkipBlank()
+			cmd.attr, cmd.re, err = s.readAttribute()
+			if err != nil {
+				return nil, err
+			}
+		} else if ct.regexp {
 			nc, ns := s.peek()
 			if (ct.cmdc != uint16('x') && ct.cmdc != uint16('X')) ||
 				((!ns) || (nc != ' ' && nc != '\t' && nc != '\n')) {
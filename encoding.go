@@ -0,0 +1,353 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// Encoding is how the text of an EncodedFile is stored on disk.
+type Encoding int
+
+const (
+	UTF8 Encoding = iota
+	UTF16LE
+	UTF16BE
+	// Latin1 is ISO 8859-1, where each byte is the character of the same
+	// code point.
+	Latin1
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case UTF8:
+		return "utf-8"
+	case UTF16LE:
+		return "utf-16le"
+	case UTF16BE:
+		return "utf-16be"
+	case Latin1:
+		return "latin-1"
+	}
+	return fmt.Sprintf("encoding %d", int(e))
+}
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// EncodedFile is a file on disk seen by commands as UTF-8 text with LF line
+// endings, whatever its encoding and line endings are. Compose writes the
+// file back in its encoding, with its byte order mark if it had one, and
+// every line ending as it was. New lines end the way most lines of the file
+// did. The text is kept in memory as a TextFile, so positions count runes.
+type EncodedFile struct {
+	*TextFile
+	name     string
+	encoding Encoding
+	bom      bool
+	crlf     bool    // new lines end in CRLF
+	crlfs    []int64 // positions of the newlines ending in CRLF on disk
+}
+
+// OpenEncodedFile opens the named file, detecting its encoding from its byte
+// order mark or, without one, from its contents: text that is not valid
+// UTF-8 is taken as UTF-16 when it looks like it, and as Latin-1 otherwise.
+func OpenEncodedFile(name string) (*EncodedFile, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return newEncodedFile(name, data, detectEncoding(data))
+}
+
+// OpenEncodedFileEncoding opens the named file in the given encoding.
+func OpenEncodedFileEncoding(name string, encoding Encoding) (*EncodedFile, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return newEncodedFile(name, data, encoding)
+}
+
+func newEncodedFile(name string, data []byte, encoding Encoding) (*EncodedFile, error) {
+	f := &EncodedFile{
+		name:     name,
+		encoding: encoding,
+	}
+	if bom := f.byteOrderMark(); bom != nil && bytes.HasPrefix(data, bom) {
+		f.bom = true
+		data = data[len(bom):]
+	}
+	text, err := decodeText(data, encoding)
+	if err != nil {
+		return nil, err
+	}
+	view := make([]rune, 0, len(text))
+	lf := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\r' && i+1 < len(text) && text[i+1] == '\n' {
+			f.crlfs = append(f.crlfs, int64(len(view)))
+			continue
+		}
+		if text[i] == '\n' {
+			lf++
+		}
+		view = append(view, text[i])
+	}
+	f.crlf = len(f.crlfs) > lf-len(f.crlfs)
+	f.TextFile = &TextFile{
+		text: view,
+	}
+	return f, nil
+}
+
+func detectEncoding(data []byte) Encoding {
+	switch {
+	case bytes.HasPrefix(data, utf8BOM):
+		return UTF8
+	case bytes.HasPrefix(data, utf16LEBOM):
+		return UTF16LE
+	case bytes.HasPrefix(data, utf16BEBOM):
+		return UTF16BE
+	case utf8.Valid(data) && bytes.IndexByte(data, 0) < 0:
+		return UTF8
+	}
+	// Text in UTF-16 has the zero high bytes of ASCII characters, newlines
+	// included, in every other byte. The few characters with a zero low
+	// byte, such as 一 (U+4E00), put some zeros in the other bytes. Little
+	// endian is tried first when neither has more.
+	zeros := [2]int{}
+	for i, b := range data {
+		if b == 0 {
+			zeros[i%2]++
+		}
+	}
+	encodings := []Encoding{UTF16LE, UTF16BE}
+	if zeros[0] > zeros[1] {
+		encodings = encodings[1:]
+	} else if zeros[1] > zeros[0] {
+		encodings = encodings[:1]
+	}
+	for _, encoding := range encodings {
+		if len(data)%2 != 0 || zeros[0]+zeros[1] == 0 {
+			break
+		}
+		if text, err := decodeText(data, encoding); err == nil && plainText(text) {
+			return encoding
+		}
+	}
+	if utf8.Valid(data) {
+		return UTF8
+	}
+	return Latin1
+}
+
+// plainText reports if text has no control characters apart from white
+// space, as binary data read as text would.
+func plainText(text []rune) bool {
+	for _, r := range text {
+		if (r < 0x20 && !strings.ContainsRune("\t\n\v\f\r", r)) || (r >= 0x7f && r < 0xa0) || r == 0xfffe || r == 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *EncodedFile) Encoding() Encoding {
+	return f.encoding
+}
+
+// CRLF reports if new lines are written with CRLF line endings.
+func (f *EncodedFile) CRLF() bool {
+	return f.crlf
+}
+
+func (f *EncodedFile) BOM() bool {
+	return f.bom
+}
+
+func (f *EncodedFile) byteOrderMark() []byte {
+	switch f.encoding {
+	case UTF8:
+		return utf8BOM
+	case UTF16LE:
+		return utf16LEBOM
+	case UTF16BE:
+		return utf16BEBOM
+	}
+	return nil
+}
+
+// Compose applies the plain text delta d and writes the file back, through
+// a temporary file replacing it so a failure leaves it as it was.
+func (f *EncodedFile) Compose(d delta.Delta) error {
+	if len(d.Ops) == 0 {
+		return nil
+	}
+	text, changes, crlfs := f.text, f.changes, f.crlfs
+	q0, q1 := f.Dot()
+	err := f.TextFile.Compose(d)
+	if err != nil {
+		return err
+	}
+	f.crlfs = f.transformCRLFs(d)
+	err = f.write()
+	if err != nil {
+		f.text, f.changes, f.crlfs = text, changes, crlfs
+		f.Select(q0, q1)
+		return err
+	}
+	return nil
+}
+
+// transformCRLFs returns where the newlines ending in CRLF end up once d
+// applies, together with the newlines d inserts when new lines end in CRLF.
+func (f *EncodedFile) transformCRLFs(d delta.Delta) []int64 {
+	crlfs := make([]int64, 0, len(f.crlfs))
+	i := 0
+	p, q := int64(0), int64(0)
+	for _, op := range d.Ops {
+		switch {
+		case op.Retain != nil:
+			n := int64(*op.Retain)
+			for ; i < len(f.crlfs) && f.crlfs[i] < p+n; i++ {
+				crlfs = append(crlfs, f.crlfs[i]-p+q)
+			}
+			p += n
+			q += n
+		case op.Delete != nil:
+			p += int64(*op.Delete)
+			for i < len(f.crlfs) && f.crlfs[i] < p {
+				i++
+			}
+		default:
+			for k, r := range op.Insert {
+				if r == '\n' && f.crlf {
+					crlfs = append(crlfs, q+int64(k))
+				}
+			}
+			q += int64(len(op.Insert))
+		}
+	}
+	for ; i < len(f.crlfs); i++ {
+		crlfs = append(crlfs, f.crlfs[i]-p+q)
+	}
+	return crlfs
+}
+
+func (f *EncodedFile) write() error {
+	name, err := filepath.EvalSymlinks(f.name)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if f.bom {
+		b.Write(f.byteOrderMark())
+	}
+	i := 0
+	for p, r := range f.text {
+		if i < len(f.crlfs) && f.crlfs[i] == int64(p) {
+			f.encodeRune(&b, '\r')
+			i++
+		}
+		err = f.encodeRune(&b, r)
+		if err != nil {
+			return err
+		}
+	}
+	tmp, err := writeTemp(name, stat.Mode().Perm(), func(w io.Writer) error {
+		_, err := b.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, name)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(name))
+	return nil
+}
+
+func (f *EncodedFile) encodeRune(b *bytes.Buffer, r rune) error {
+	switch f.encoding {
+	case UTF16LE, UTF16BE:
+		units := []uint16{uint16(r)}
+		if r >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(r)
+			units = []uint16{uint16(r1), uint16(r2)}
+		}
+		for _, u := range units {
+			if f.encoding == UTF16LE {
+				b.WriteByte(byte(u))
+				b.WriteByte(byte(u >> 8))
+			} else {
+				b.WriteByte(byte(u >> 8))
+				b.WriteByte(byte(u))
+			}
+		}
+	case Latin1:
+		if r > 0xff {
+			return fmt.Errorf("Can't write %q in %s!", r, f.encoding)
+		}
+		b.WriteByte(byte(r))
+	default:
+		b.WriteRune(r)
+	}
+	return nil
+}
+
+// decodeText returns data in encoding as runes. Only text that encodes
+// back to the same data is accepted.
+func decodeText(data []byte, encoding Encoding) ([]rune, error) {
+	switch encoding {
+	case UTF16LE, UTF16BE:
+		if len(data)%2 != 0 {
+			return nil, fmt.Errorf("Invalid %s text: odd length!", encoding)
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if encoding == UTF16LE {
+				units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+			} else {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		for i := 0; i < len(units); i++ {
+			if !utf16.IsSurrogate(rune(units[i])) {
+				continue
+			}
+			if units[i] >= 0xdc00 || i+1 == len(units) || units[i+1] < 0xdc00 || units[i+1] > 0xdfff {
+				return nil, fmt.Errorf("Invalid %s text: unpaired surrogate at byte %d!", encoding, 2*i)
+			}
+			i++
+		}
+		return utf16.Decode(units), nil
+	case Latin1:
+		text := make([]rune, len(data))
+		for i, b := range data {
+			text[i] = rune(b)
+		}
+		return text, nil
+	}
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("Invalid %s text!", encoding)
+	}
+	return []rune(string(data)), nil
+}
@@ -0,0 +1,130 @@
+package editor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+func utf16LE(s string) []byte {
+	data := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		data = append(data, byte(u), byte(u>>8))
+	}
+	return data
+}
+
+func TestEncodedFile(t *testing.T) {
+	files := []struct {
+		name     string
+		data     []byte
+		encoding Encoding
+		commands []string
+		print    string
+		expected []byte
+	}{
+		{
+			name:     "crlf.txt",
+			data:     []byte("one\r\ntwo\r\nthree\n"),
+			encoding: UTF8,
+			commands: []string{",x/o$/c/0/", "1a/new\\n/", "/three/d"},
+			print:    "new\n",
+			expected: []byte("one\r\nnew\r\ntw0\r\n\n"),
+		},
+		{
+			name:     "utf16.txt",
+			data:     utf16LE("héllo\r\nwörld 😀\r\n"),
+			encoding: UTF16LE,
+			commands: []string{"/wörld/c/world/", "$a/end\\n/"},
+			print:    "world 😀\n",
+			expected: utf16LE("héllo\r\nworld 😀\r\nend\r\n"),
+		},
+		{
+			name:     "utf16-nobom.txt",
+			data:     utf16LE("一行\r\n二行\r\n")[2:],
+			encoding: UTF16LE,
+			commands: []string{"/二/c/三/"},
+			print:    "三行\n",
+			expected: utf16LE("一行\r\n三行\r\n")[2:],
+		},
+		{
+			name:     "latin1.txt",
+			data:     []byte("caf\xe9\nna\xefve\n"),
+			encoding: Latin1,
+			commands: []string{"/caf./s/é/e/", "/naïve/c/naïf/"},
+			print:    "naïf\n",
+			expected: []byte("cafe\nna\xeff\n"),
+		},
+	}
+	dir := t.TempDir()
+	for _, file := range files {
+		name := filepath.Join(dir, file.name)
+		err := os.WriteFile(name, file.data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := OpenEncodedFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Encoding() != file.encoding {
+			t.Fatalf("%s: invalid encoding, expected: %s, actual: %s", file.name, file.encoding, e.Encoding())
+		}
+		for _, command := range file.commands {
+			err := run(command, e)
+			if err != nil {
+				t.Fatalf("%s: %s: %v", file.name, command, err)
+			}
+		}
+		var printed bytes.Buffer
+		cmd, err := Compile("2p")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmd.Run(Context{
+			File:    e,
+			Printer: &printed,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printed.String() != file.print {
+			t.Fatalf("%s: invalid print, expected: %q, actual: %q", file.name, file.print, printed.String())
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, file.expected) {
+			t.Fatalf("%s: invalid content, expected: %q, actual: %q", file.name, file.expected, data)
+		}
+	}
+	e, err := OpenEncodedFile(filepath.Join(dir, "latin1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run("1c/€/", e); err == nil {
+		t.Fatal("Expected writing € in latin-1 to fail")
+	}
+	if e.String() != "cafe\nnaïf\n" {
+		t.Fatalf("Invalid content after a failed write: %q", e.String())
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	for _, test := range []struct {
+		data     []byte
+		encoding Encoding
+	}{
+		{utf16LE("一二三\n")[2:], UTF16LE},
+		{[]byte{0x4e, 0x00, 0x00, 0x20, 0x00, 0x61, 0x00, 0x0a}, UTF16BE},
+		{[]byte("caf\xe9\x00\x01\x02\x00"), Latin1},
+		{[]byte("plain\n"), UTF8},
+	} {
+		if encoding := detectEncoding(test.data); encoding != test.encoding {
+			t.Fatalf("Invalid encoding of %q, expected: %s, actual: %s", test.data, test.encoding, encoding)
+		}
+	}
+}
@@ -1,15 +1,18 @@
 package editor
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/fmpwizard/go-quilljs-delta/delta"
 )
 
 type GoFileFile struct {
 	file       *os.File
+	owned      bool // file was opened by Compose, so it closes it
 	start, end int64
 }
 
@@ -30,15 +33,134 @@ func (f *GoFileFile) Dot() (start, end int64) {
 	return
 }
 
-// For now, we would only use  GoFileFile in a readonly fashion, later we
-// might visit again to see if we can provide proper Compose implementation.
+// Compose applies a plain text delta by streaming the file through the
+// changes into a temporary file next to it, which then replaces the file.
+// The file is never read into memory as a whole, and keeps its permissions.
+// Afterwards GoFileFile reads from the new file, which Close closes, the
+// *os.File it was created with is left open for its owner to close.
 func (f *GoFileFile) Compose(d delta.Delta) error {
-	if len(d.Ops) > 0 {
-		return fmt.Errorf("GoFileFile is read only for now!")
+	if len(d.Ops) == 0 {
+		return nil
 	}
+	err := checkPlainText(d, "GoFileFile")
+	if err != nil {
+		return err
+	}
+	name, err := filepath.EvalSymlinks(f.file.Name())
+	if err != nil {
+		return err
+	}
+	stat, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := writeTemp(name, stat.Mode().Perm(), func(w io.Writer) error {
+		return f.writeTo(w, d, stat.Size())
+	})
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, name)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(name))
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	if f.owned {
+		f.file.Close()
+	}
+	f.file = file
+	f.owned = true
 	return nil
 }
 
+// Close closes the file Compose opened, if any. The GoFileFile can't be read
+// from afterwards unless it still reads the *os.File it was created with.
+func (f *GoFileFile) Close() error {
+	if !f.owned {
+		return nil
+	}
+	f.owned = false
+	return f.file.Close()
+}
+
+// writeTo writes the contents of the file with d applied to w, the file
+// being size bytes long.
+func (f *GoFileFile) writeTo(w io.Writer, d delta.Delta, size int64) error {
+	b := bufio.NewWriter(w)
+	p := int64(0)
+	for _, op := range d.Ops {
+		switch {
+		case op.Retain != nil:
+			n := int64(*op.Retain)
+			if p+n > size {
+				return fmt.Errorf("Retain past the end of file at #%d!", p)
+			}
+			_, err := io.Copy(b, io.NewSectionReader(f.file, p, n))
+			if err != nil {
+				return err
+			}
+			p += n
+		case op.Delete != nil:
+			n := int64(*op.Delete)
+			if p+n > size {
+				return fmt.Errorf("Delete past the end of file at #%d!", p)
+			}
+			p += n
+		case op.Insert != nil:
+			_, err := b.WriteString(string(op.Insert))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err := io.Copy(b, io.NewSectionReader(f.file, p, size-p))
+	if err != nil {
+		return err
+	}
+	return b.Flush()
+}
+
+// writeTemp creates a file with permissions perm next to name, to replace
+// it later on, and fills it with write. The file is synced before its name
+// is returned.
+func writeTemp(name string, perm os.FileMode, write func(w io.Writer) error) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return "", err
+	}
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// syncDir makes a rename in dir durable. Not all systems can sync a
+// directory, the file itself is synced already so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
 func (f *GoFileFile) Len() (int64, error) {
 	stat, err := f.file.Stat()
 	if err != nil {
@@ -67,24 +189,24 @@ func (r *goFileReader) Len() int64 {
 	return r.end - r.start
 }
 
+// Read uses ReadAt, so readers of the same file do not move each other's
+// offset.
 func (r *goFileReader) Read(p []byte) (int, error) {
 	offset := r.offset + r.start
-	_, err := r.file.file.Seek(offset, io.SeekStart)
-	if err != nil {
-		return -1, err
-	}
 	remaining := r.end - offset
-	originalLen := int64(len(p))
-	if remaining < originalLen {
-		p = p[0:remaining]
+	if remaining <= 0 {
+		return 0, io.EOF
 	}
-	n, err := r.file.file.Read(p)
-	if err == nil && remaining < originalLen {
-		err = io.EOF
+	if remaining < int64(len(p)) {
+		p = p[0:remaining]
 	}
+	n, err := r.file.file.ReadAt(p, offset)
 	if n > 0 {
 		r.offset += int64(n)
 	}
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
 	return n, err
 }
 
@@ -2,7 +2,10 @@ package editor
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -54,3 +57,164 @@ func TestDot(t *testing.T) {
 		t.Fatalf("Invalid dot! Expected: (14, 23), actual: (%d, %d)", q0, q1)
 	}
 }
+
+func TestFileCompose(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "testfile.txt")
+	data, err := os.ReadFile("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(name, data, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	e := NewGoFile(file)
+	for _, command := range []string{",x/vim/c/vi/", "$-d", "2a/ed\\n/"} {
+		cmd, err := Compile(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmd.Run(Context{File: e})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedContent := "vi\nEmacs sam\ned\nacme vi\ntext\n"
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != expectedContent {
+		t.Fatalf("Invalid content! Expected: %q, actual: %q", expectedContent, content)
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm() != 0640 {
+		t.Fatalf("Invalid permissions! Expected: %v, actual: %v", os.FileMode(0640), stat.Mode().Perm())
+	}
+	l, err := e.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l != int64(len(expectedContent)) {
+		t.Fatalf("Invalid length! Expected: %d, actual: %d", len(expectedContent), l)
+	}
+	cmd, err := Compile("1fmt bold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Run(Context{File: e}); err == nil {
+		t.Fatal("Expected formatting a GoFileFile to fail")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the file to be left, found %d entries", len(entries))
+	}
+	opened := e.file
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opened.Stat(); err == nil {
+		t.Fatal("Expected the file opened by Compose to be closed")
+	}
+	if _, err := file.Stat(); err != nil {
+		t.Fatalf("File of the caller closed: %v", err)
+	}
+}
+
+func TestReaderAtFile(t *testing.T) {
+	e, err := OpenReaderAtFile("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	buf := bytes.NewBuffer(nil)
+	cmd, err := Compile(",x/vim/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Run(Context{
+		File:    e,
+		Printer: buf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "vimvim" {
+		t.Fatalf("Invalid printer value! Expected: vimvim, actual: %s", buf.String())
+	}
+	cmd, err = Compile("1d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Run(Context{File: e}); err == nil {
+		t.Fatal("Expected changing a ReaderAtFile to fail")
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	expected, err := os.ReadFile("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	opened, err := OpenReaderAtFile("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+	mapped, err := OpenMappedReaderAtFile("testfile.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapped.Close()
+	if opened.data != nil {
+		t.Fatal("Expected OpenReaderAtFile not to map the file")
+	}
+	for _, f := range []File{NewGoFile(file), NewReaderAtFile(file, int64(len(expected))), opened, mapped} {
+		errs := make(chan error)
+		for i := 0; i < 8; i++ {
+			go func() {
+				r := f.Reader(0, int64(len(expected)))
+				data := make([]byte, 0, len(expected))
+				p := make([]byte, 3)
+				for {
+					n, err := r.Read(p)
+					data = append(data, p[:n]...)
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+				if !bytes.Equal(data, expected) {
+					errs <- fmt.Errorf("Invalid content: %q", data)
+					return
+				}
+				errs <- nil
+			}()
+		}
+		for i := 0; i < 8; i++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("%T: %v", f, err)
+			}
+		}
+	}
+}
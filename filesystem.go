@@ -0,0 +1,58 @@
+package editor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS is the filesystem used by the r, w and e commands. The read side is a
+// plain io/fs file system, so in-memory implementations such as
+// fstest.MapFS only need a WriteFile to be used here.
+type FS interface {
+	fs.FS
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// DirFS is an FS for the files of the operating system below the directory
+// DirFS names. As for any fs.FS, names are slash separated and relative,
+// absolute names and names with .. elements are rejected, so commands can't
+// reach outside of the directory.
+type DirFS string
+
+func (dir DirFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(dir), filepath.FromSlash(name)), nil
+}
+
+func (dir DirFS) Open(name string) (fs.File, error) {
+	p, err := dir.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (dir DirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := dir.path("write", name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, perm)
+}
+
+// osFS is used when Context.FS is not set. Names are those of the operating
+// system as sam takes them, relative ones start from the current directory
+// of the process and absolute ones are used as they are. It does not follow
+// the naming rules of fs.FS, so it is not exported.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
@@ -0,0 +1,37 @@
+package editor
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirFS(t *testing.T) {
+	dir := t.TempDir()
+	fsys := DirFS(filepath.Join(dir, "root"))
+	err := os.Mkdir(string(fsys), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fsys.WriteFile("a.txt", []byte("a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(fsys, "a.txt")
+	if err != nil || string(data) != "a\n" {
+		t.Fatalf("Invalid data read: \"%s\", %v", data, err)
+	}
+	for _, name := range []string{"../b.txt", filepath.Join(dir, "b.txt"), "/b.txt", "./a.txt", ""} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("Expected %s to be rejected by Open, got %v", name, err)
+		}
+		if err := fsys.WriteFile(name, []byte("b\n"), 0644); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("Expected %s to be rejected by WriteFile, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.txt")); err == nil {
+		t.Fatal("File written outside of the DirFS")
+	}
+}
@@ -1,5 +1,5 @@
 module xuejie.space/c/go-quill-editor
 
-go 1.13
+go 1.16
 
 require github.com/fmpwizard/go-quilljs-delta v0.0.6-0.20190628042138-9bdce66302c2
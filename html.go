@@ -0,0 +1,264 @@
+package editor
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// HTMLRenderer renders Quill documents, such as the Delta of a DeltaFile,
+// as semantic HTML. The output only depends on the document, so it can be
+// compared as is.
+type HTMLRenderer struct {
+	// Embeds renders embeds by their type, taking precedence over the
+	// built-in rendering of image, video and formula. What it returns is
+	// written out without escaping.
+	Embeds map[string]func(value interface{}, attrs map[string]interface{}) string
+}
+
+// RenderHTML renders d with the built-in embed renderers.
+func RenderHTML(d delta.Delta) string {
+	return (&HTMLRenderer{}).Render(d)
+}
+
+// Render returns a block element for each line of d, with consecutive list
+// items grouped in lists nested by their indent and consecutive code block
+// lines in a single pre. A last line without newline is only rendered
+// inline, so parts of a line render as the text they hold.
+func (r *HTMLRenderer) Render(d delta.Delta) string {
+	w := &htmlWriter{}
+	lines := splitLines(d)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if !line.ended {
+			w.closeLists(0)
+			w.WriteString(r.inline(line.ops))
+			continue
+		}
+		if list, ok := line.attrs["list"].(string); ok {
+			w.listItem(list, line, r.lineContent(line))
+			continue
+		}
+		w.closeLists(0)
+		if language := line.attrs["code-block"]; language != nil {
+			code := make([]string, 0)
+			for ; i < len(lines) && lines[i].ended && lines[i].attrs["code-block"] == language; i++ {
+				code = append(code, html.EscapeString(printableText(delta.Delta{Ops: lines[i].ops})))
+			}
+			i--
+			class := ""
+			if s, ok := language.(string); ok && s != "plain" {
+				class = fmt.Sprintf(" class=\"language-%s\"", html.EscapeString(s))
+			}
+			fmt.Fprintf(w, "<pre><code%s>%s</code></pre>\n", class, strings.Join(code, "\n"))
+			continue
+		}
+		tag := "p"
+		if n := attributeNumber(line.attrs["header"]); n >= 1 && n <= 6 {
+			tag = fmt.Sprintf("h%d", int(n))
+		} else if line.attrs["blockquote"] == true {
+			tag = "blockquote"
+		}
+		fmt.Fprintf(w, "<%s%s>%s</%s>\n", tag, blockAttributes(line.attrs), r.lineContent(line), tag)
+	}
+	w.closeLists(0)
+	return w.String()
+}
+
+// htmlWriter keeps track of the lists open while rendering.
+type htmlWriter struct {
+	strings.Builder
+	lists []string // tags of the open lists, innermost last
+}
+
+// closeLists closes the lists nested deeper than depth.
+func (w *htmlWriter) closeLists(depth int) {
+	for len(w.lists) > depth {
+		fmt.Fprintf(w, "</li></%s>", w.lists[len(w.lists)-1])
+		w.lists = w.lists[:len(w.lists)-1]
+		if len(w.lists) == 0 {
+			w.WriteString("\n")
+		}
+	}
+}
+
+// listItem starts an item of a list, opening and closing lists so it ends
+// up at the depth given by its indent.
+func (w *htmlWriter) listItem(list string, line docLine, content string) {
+	tag := "ul"
+	if list == "ordered" {
+		tag = "ol"
+	}
+	depth := int(attributeNumber(line.attrs["indent"])) + 1
+	w.closeLists(depth)
+	if len(w.lists) == depth {
+		if w.lists[depth-1] == tag {
+			w.WriteString("</li>")
+		} else {
+			w.closeLists(depth - 1)
+		}
+	}
+	for len(w.lists) < depth {
+		fmt.Fprintf(w, "<%s>", tag)
+		w.lists = append(w.lists, tag)
+		if len(w.lists) < depth {
+			w.WriteString("<li>")
+		}
+	}
+	attrs := blockAttributes(line.attrs)
+	if list == "checked" || list == "unchecked" {
+		attrs += fmt.Sprintf(" data-checked=\"%t\"", list == "checked")
+	}
+	fmt.Fprintf(w, "<li%s>%s", attrs, content)
+}
+
+func (r *HTMLRenderer) lineContent(line docLine) string {
+	content := r.inline(line.ops)
+	if content == "" {
+		return "<br>"
+	}
+	return content
+}
+
+// blockAttributes returns the HTML attributes for the align and direction
+// formats of a line.
+func blockAttributes(attrs map[string]interface{}) string {
+	result := ""
+	switch align := attrs["align"]; align {
+	case "left", "center", "right", "justify":
+		result += fmt.Sprintf(" style=\"text-align: %s\"", align)
+	}
+	switch direction := attrs["direction"]; direction {
+	case "ltr", "rtl":
+		result += fmt.Sprintf(" dir=\"%s\"", direction)
+	}
+	return result
+}
+
+var (
+	colorValue = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|rgba?\([0-9., %]*\))$`)
+	fontValue  = regexp.MustCompile(`^[a-zA-Z0-9 ,-]+$`)
+	sizeValue  = regexp.MustCompile(`^([0-9.]+(px|em|rem|pt|%)?|[a-zA-Z-]+)$`)
+)
+
+// inlineStyles are the inline formats rendered as CSS, in the order they
+// are written. Values not matching valid are dropped, so a format can't
+// write other properties.
+var inlineStyles = []struct {
+	attr, property string
+	valid          *regexp.Regexp
+}{
+	{"background", "background-color", colorValue},
+	{"color", "color", colorValue},
+	{"font", "font-family", fontValue},
+	{"size", "font-size", sizeValue},
+}
+
+// sanitizeURL returns url when its scheme is one Quill allows for links,
+// or for images when image is set, and about:blank otherwise. URLs without
+// a scheme are relative and kept.
+func sanitizeURL(url string, image bool) string {
+	// Browsers drop tabs and newlines anywhere and leading spaces and
+	// control characters, so "java\tscript:" is still javascript:.
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, url)
+	cleaned = strings.TrimLeftFunc(cleaned, func(r rune) bool {
+		return r <= ' '
+	})
+	i := strings.IndexAny(cleaned, ":/?#")
+	if i < 0 || cleaned[i] != ':' {
+		return url
+	}
+	switch strings.ToLower(cleaned[:i]) {
+	case "http", "https":
+		return url
+	case "mailto", "tel":
+		if !image {
+			return url
+		}
+	case "data":
+		if image && strings.HasPrefix(strings.ToLower(cleaned), "data:image/") {
+			return url
+		}
+	}
+	return "about:blank"
+}
+
+// inline renders the text and embeds of a line, wrapping each in elements
+// for its inline formats, innermost first.
+func (r *HTMLRenderer) inline(ops []delta.Op) string {
+	var b strings.Builder
+	for _, op := range ops {
+		var content string
+		if op.InsertEmbed != nil {
+			content = r.embed(op.InsertEmbed, op.Attributes)
+		} else {
+			content = html.EscapeString(string(op.Insert))
+		}
+		for _, format := range []struct {
+			attr, tag string
+		}{
+			{"code", "code"},
+			{"strike", "s"},
+			{"underline", "u"},
+			{"italic", "em"},
+			{"bold", "strong"},
+		} {
+			if op.Attributes[format.attr] == true {
+				content = fmt.Sprintf("<%s>%s</%s>", format.tag, content, format.tag)
+			}
+		}
+		switch op.Attributes["script"] {
+		case "sub":
+			content = fmt.Sprintf("<sub>%s</sub>", content)
+		case "super":
+			content = fmt.Sprintf("<sup>%s</sup>", content)
+		}
+		styles := make([]string, 0)
+		for _, style := range inlineStyles {
+			if value, ok := op.Attributes[style.attr].(string); ok && style.valid.MatchString(value) {
+				styles = append(styles, fmt.Sprintf("%s: %s", style.property, value))
+			}
+		}
+		if len(styles) > 0 {
+			content = fmt.Sprintf("<span style=\"%s\">%s</span>",
+				html.EscapeString(strings.Join(styles, "; ")), content)
+		}
+		if link, ok := op.Attributes["link"].(string); ok {
+			content = fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(sanitizeURL(link, false)), content)
+		}
+		b.WriteString(content)
+	}
+	return b.String()
+}
+
+func (r *HTMLRenderer) embed(embed *delta.Embed, attrs map[string]interface{}) string {
+	if render, ok := r.Embeds[embed.Key]; ok {
+		return render(embed.Value, attrs)
+	}
+	value := html.EscapeString(embedValue(embed.Value))
+	switch embed.Key {
+	case "image":
+		extra := ""
+		for _, attr := range []string{"alt", "width", "height"} {
+			if v, ok := attrs[attr]; ok && v != nil {
+				extra += fmt.Sprintf(" %s=\"%s\"", attr, html.EscapeString(embedValue(v)))
+			}
+		}
+		src := html.EscapeString(sanitizeURL(embedValue(embed.Value), true))
+		return fmt.Sprintf("<img src=\"%s\"%s>", src, extra)
+	case "video":
+		src := html.EscapeString(sanitizeURL(embedValue(embed.Value), false))
+		return fmt.Sprintf("<iframe src=\"%s\" allowfullscreen></iframe>", src)
+	case "formula":
+		return fmt.Sprintf("<span class=\"ql-formula\">%s</span>", value)
+	}
+	return fmt.Sprintf("<span class=\"ql-%s\">%s</span>", html.EscapeString(embed.Key), value)
+}
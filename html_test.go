@@ -0,0 +1,75 @@
+package editor
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func readDelta(t *testing.T, name string) delta.Delta {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d delta.Delta
+	err = json.Unmarshal(data, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// checkGolden compares actual with the golden file name, or rewrites it
+// when running with -update.
+func checkGolden(t *testing.T, name string, actual string) {
+	if *update {
+		err := ioutil.WriteFile(name, []byte(actual), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != string(expected) {
+		t.Fatalf("Invalid result for %s, expected: \"%s\", actual: \"%s\"", name, string(expected), actual)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	names, err := filepath.Glob(filepath.Join("testdata", "html", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		d := readDelta(t, name)
+		checkGolden(t, strings.TrimSuffix(name, ".json")+".html", RenderHTML(d))
+	}
+}
+
+func TestRenderHTMLCustomEmbed(t *testing.T) {
+	r := &HTMLRenderer{
+		Embeds: map[string]func(value interface{}, attrs map[string]interface{}) string{
+			"image": func(value interface{}, attrs map[string]interface{}) string {
+				return "<figure>" + value.(string) + "</figure>"
+			},
+		},
+	}
+	d := *delta.New(nil).InsertEmbed(delta.Embed{
+		Key:   "image",
+		Value: "a.png",
+	}, nil).Insert("\n", nil)
+	expected := "<p><figure>a.png</figure></p>\n"
+	if actual := r.Render(d); actual != expected {
+		t.Fatalf("Invalid result, expected: \"%s\", actual: \"%s\"", expected, actual)
+	}
+}
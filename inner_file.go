@@ -1,64 +1,468 @@
 package editor
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"unicode/utf8"
 
 	"github.com/fmpwizard/go-quilljs-delta/delta"
 )
 
 type innerFile struct {
 	file        File
-	changes     delta.Delta
+	name        string
+	modified    bool
+	clean       bool // the pending changes make the file match its name
+	lenient     bool
+	edits       []edit      // pending changes, unless lenient
+	changes     delta.Delta // pending changes composed, when lenient
 	originalLen int64
 	appliedLen  int64
+	dot         textRange
+	mark        textRange
+	// Commands work on bytes while the file may count another unit, index
+	// maps between the two for the original text.
+	unit  Unit
+	index *unitIndex
+}
+
+// edit replaces the range q0, q1 of the original text with text, or keeps
+// it and applies the retains in text when format is set. Like in sam, the
+// edits made by one command must not overlap and must come in ascending
+// order, so applying them is a single pass over the file.
+type edit struct {
+	q0, q1 int64
+	text   delta.Delta
+	format bool
+}
+
+// textLen returns how many bytes the inserts of d take in the text commands
+// work on, where each embed is a single byte.
+func textLen(d delta.Delta) int64 {
+	l := int64(0)
+	for _, op := range d.Ops {
+		if op.Insert != nil {
+			l += int64(len(string(op.Insert)))
+		} else if op.InsertEmbed != nil {
+			l += 1
+		}
+	}
+	return l
 }
 
 func newInnerFile(file File) (*innerFile, error) {
-	l, err := file.Len()
+	f := &innerFile{
+		file: file,
+		unit: fileUnit(file),
+	}
+	err := f.reload()
 	if err != nil {
 		return nil, err
 	}
-	return &innerFile{
-		file:        file,
-		originalLen: l,
-		appliedLen:  l,
-	}, nil
+	if m, ok := file.(MarkFile); ok {
+		f.setUnitMark(m.Mark())
+	}
+	if n, ok := file.(NamedFile); ok {
+		f.name = n.Name()
+	}
+	return f, nil
+}
+
+// setUnitMark and unitMark set and return the mark as positions of the
+// file, for keeping it between Runs.
+func (f *innerFile) setUnitMark(q0, q1 int64) {
+	f.mark.q0, f.mark.q1 = f.index.toByte(q0), f.index.toByte(q1)
+}
+
+func (f *innerFile) unitMark() (int64, int64) {
+	return f.index.fromByte(f.mark.q0), f.index.fromByte(f.mark.q1)
+}
+
+// reload reads the length and dot of the file after it changed.
+func (f *innerFile) reload() error {
+	l, err := f.file.Len()
+	if err != nil {
+		return err
+	}
+	f.index, err = newUnitIndex(f.file.Reader(0, l), f.unit)
+	if err != nil {
+		return err
+	}
+	f.originalLen = f.index.toByte(l)
+	f.appliedLen = f.originalLen
+	q0, q1 := f.file.Dot()
+	f.dot.q0, f.dot.q1 = f.index.toByte(q0), f.index.toByte(q1)
+	return nil
+}
+
+// update moves the index along with d, a byte delta the file just composed,
+// reading only the inserted text. Should the file not end up as long as d
+// makes it, it is read again as a whole.
+func (f *innerFile) update(d delta.Delta) error {
+	l, err := f.file.Len()
+	if err != nil {
+		return err
+	}
+	length := f.originalLen
+	for _, op := range d.Ops {
+		if op.Delete != nil {
+			length -= int64(*op.Delete)
+		} else if op.Retain == nil {
+			length += textLen(*delta.New([]delta.Op{op}))
+		}
+	}
+	index := f.index.apply(d)
+	if index.fromByte(length) != l {
+		return f.reload()
+	}
+	f.index = index
+	f.originalLen = length
+	f.appliedLen = length
+	return nil
+}
+
+// unitPosition returns byte offset p of the original text as a position of
+// the file, as #n addresses and = count them.
+func (f *innerFile) unitPosition(p int64) int64 {
+	return f.index.fromByte(p)
+}
+
+// bytePosition returns the byte offset of position p of the file.
+func (f *innerFile) bytePosition(p int64) int64 {
+	return f.index.toByte(p)
 }
 
 func (f *innerFile) updateAppliedLen(d *delta.Delta) {
 	f.appliedLen = int64(d.TransformPosition(int(f.appliedLen), false))
 }
 
+func (f *innerFile) pending() bool {
+	return len(f.edits) > 0 || len(f.changes.Ops) > 0
+}
+
+// delta returns the pending changes as a delta against the original file.
+func (f *innerFile) delta() delta.Delta {
+	if f.lenient {
+		return f.changes
+	}
+	d := delta.New(nil)
+	p := int64(0)
+	for _, e := range f.edits {
+		d.Retain(int(e.q0-p), nil)
+		if !e.format {
+			d.Delete(int(e.q1 - e.q0))
+		}
+		for _, op := range e.text.Ops {
+			d.Push(op)
+		}
+		p = e.q1
+	}
+	return *d
+}
+
+// transform maps position p of the original file to where it ends up once
+// the pending changes apply. Text inserted right at p moves it only when
+// after is set.
+func (f *innerFile) transform(p int64, after bool) int64 {
+	if f.lenient {
+		return int64(f.changes.TransformPosition(int(p), !after))
+	}
+	shift := int64(0)
+	for _, e := range f.edits {
+		if e.q0 > p || (e.q0 == p && (e.q1 > p || !after)) {
+			break
+		}
+		if e.format {
+			continue
+		}
+		if e.q1 > p {
+			return e.q0 + shift
+		}
+		shift += textLen(e.text) - (e.q1 - e.q0)
+	}
+	return p + shift
+}
+
+// checkSequence enforces the rule of sam that changes must come in order.
+func (f *innerFile) checkSequence(q0, q1 int64) error {
+	if len(f.edits) == 0 {
+		return nil
+	}
+	last := f.edits[len(f.edits)-1]
+	if q0 < last.q1 {
+		return fmt.Errorf("Changes not in sequence: #%d,#%d comes before the earlier change at #%d,#%d",
+			f.unitPosition(q0), f.unitPosition(q1), f.unitPosition(last.q0), f.unitPosition(last.q1))
+	}
+	return nil
+}
+
+// Validate checks the pending changes against schema. Formats retained
+// over the original text are checked against the text they cover.
+func (f *innerFile) Validate(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	// Inserts are reported where the text they replace starts.
+	p, at := int64(0), int64(0)
+	for _, op := range f.delta().Ops {
+		switch {
+		case op.Delete != nil:
+			p += int64(*op.Delete)
+		case op.Retain != nil:
+			n := int64(*op.Retain)
+			if len(op.Attributes) > 0 {
+				err := f.validateRetain(schema, p, p+n, op.Attributes)
+				if err != nil {
+					return err
+				}
+			}
+			p += n
+			at = p
+		default:
+			err := schema.check(op, f.unitPosition(at), f.unit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateRetain checks attrs retained over the range start, end of the
+// original text.
+func (f *innerFile) validateRetain(schema *Schema, start, end int64, attrs map[string]interface{}) error {
+	d, err := f.Text(start, end)
+	if err != nil {
+		return err
+	}
+	p := start
+	for _, op := range d.Ops {
+		if op.InsertEmbed != nil {
+			err = schema.checkFormats(attrs, f.unitPosition(p), schema.Inline, schema.EmbedFormats)
+		} else {
+			err = schema.check(delta.Op{Insert: op.Insert, Attributes: attrs}, f.unitPosition(p), f.unit)
+		}
+		if err != nil {
+			return err
+		}
+		p += textLen(*delta.New([]delta.Op{op}))
+	}
+	return nil
+}
+
 func (f *innerFile) Commit() error {
-	err := f.file.Compose(f.changes)
+	d := f.delta()
+	err := f.file.Compose(convertDelta(d, f.index.fromByte))
 	if err != nil {
 		return err
 	}
+	// The mark is kept in the coordinates of the original file while a
+	// command runs, now move it along with the changes just applied.
+	f.mark.q0 = f.transform(f.mark.q0, false)
+	f.mark.q1 = f.transform(f.mark.q1, false)
+	dot := f.dot
+	f.edits = nil
 	f.changes = *delta.New(nil)
-	f.originalLen, err = f.file.Len()
+	err = f.update(d)
+	if err != nil {
+		return err
+	}
+	f.dot = dot
+	f.file.Select(f.index.fromByte(dot.q0), f.index.fromByte(dot.q1))
+	if m, ok := f.file.(MarkFile); ok {
+		m.SetMark(f.unitMark())
+	}
+	return nil
+}
+
+// Undo reverts n composed deltas of the underlying file, or redoes -n of
+// them when n is negative. Pending changes would be relative to the text
+// before undoing, so they are not allowed.
+func (f *innerFile) Undo(n int64) error {
+	undoer, ok := f.file.(Undoer)
+	if !ok {
+		return fmt.Errorf("File does not support undo!")
+	}
+	if f.pending() {
+		return fmt.Errorf("Can't undo with pending changes!")
+	}
+	for ; n > 0 && undoer.Undo(); n-- {
+	}
+	for ; n < 0 && undoer.Redo(); n++ {
+	}
+	f.modified = true
+	return f.reload()
+}
+
+// InsertDelta inserts the contents of d, which may carry attributes and
+// embeds, at position at of the original file.
+func (f *innerFile) InsertDelta(d delta.Delta, at int64) (int64, error) {
+	if f.lenient {
+		return f.insertLenient(d, at), nil
+	}
+	l := textLen(d)
+	if at < 0 || l == 0 {
+		return 0, nil
+	}
+	if at > f.originalLen {
+		at = f.originalLen
+	}
+	err := f.checkSequence(at, at)
 	if err != nil {
+		return 0, err
+	}
+	applied := f.transform(at, true)
+	if n := len(f.edits); n > 0 && f.edits[n-1].q1 == at && !f.edits[n-1].format {
+		// Text inserted where the last change ends, as c and s do after
+		// deleting, belongs to that change.
+		last := &f.edits[n-1]
+		last.text = *delta.New(nil).Concat(last.text).Concat(d)
+	} else {
+		f.edits = append(f.edits, edit{
+			q0:   at,
+			q1:   at,
+			text: d,
+		})
+	}
+	f.appliedLen += l
+	f.Select(applied, applied+l)
+	return l, nil
+}
+
+func (f *innerFile) Delete(start, end int64) (int64, error) {
+	if f.lenient {
+		return f.deleteLenient(start, end), nil
+	}
+	if end > f.originalLen {
+		end = f.originalLen
+	}
+	if start < 0 || end <= start {
+		return 0, nil
+	}
+	err := f.checkSequence(start, end)
+	if err != nil {
+		return 0, err
+	}
+	applied := f.transform(start, true)
+	f.edits = append(f.edits, edit{
+		q0: start,
+		q1: end,
+	})
+	f.appliedLen -= end - start
+	f.Select(applied, applied)
+	return end - start, nil
+}
+
+// Format sets attrs on the range start, end without changing its text,
+// where a nil value removes the attribute. Newlines keep their attributes
+// as Quill stores line formats on them.
+func (f *innerFile) Format(start, end int64, attrs map[string]interface{}) error {
+	if end > f.originalLen {
+		end = f.originalLen
+	}
+	if start < 0 || end <= start {
 		return nil
 	}
-	f.appliedLen = f.originalLen
+	retains, err := f.inlineRetains(start, end, attrs)
+	if err != nil {
+		return err
+	}
+	return f.format(start, end, retains)
+}
+
+// FormatLine sets the line format attrs on the newline at p.
+func (f *innerFile) FormatLine(p int64, attrs map[string]interface{}) error {
+	if p < 0 || p >= f.originalLen {
+		return nil
+	}
+	return f.format(p, p+1, *delta.New(nil).Retain(1, attrs))
+}
+
+// format applies retains covering the range start, end.
+func (f *innerFile) format(start, end int64, retains delta.Delta) error {
+	if f.lenient {
+		at := int64(f.changes.TransformPosition(int(start), true))
+		change := delta.New(nil).Retain(int(at), nil).Concat(retains)
+		f.changes = *f.changes.Compose(*change)
+		f.Select(at, at+end-start)
+		return nil
+	}
+	if n := len(f.edits); n > 0 && f.edits[n-1].format && start >= f.edits[n-1].q0 && start < f.edits[n-1].q1 {
+		// Formats of the same text, such as fmt commands one after another
+		// in a block, compose into a single change.
+		last := &f.edits[n-1]
+		if end > last.q1 {
+			last.q1 = end
+		}
+		change := delta.New(nil).Retain(int(start-last.q0), nil).Concat(retains)
+		text := last.text.Compose(*change)
+		l := int64(0)
+		for _, op := range text.Ops {
+			if op.Retain != nil {
+				l += int64(*op.Retain)
+			}
+		}
+		last.text = *text.Retain(int(last.q1-last.q0-l), nil)
+		applied := f.transform(start, true)
+		f.Select(applied, applied+end-start)
+		return nil
+	}
+	err := f.checkSequence(start, end)
+	if err != nil {
+		return err
+	}
+	applied := f.transform(start, true)
+	f.edits = append(f.edits, edit{
+		q0:     start,
+		q1:     end,
+		text:   retains,
+		format: true,
+	})
+	f.Select(applied, applied+end-start)
 	return nil
 }
 
-func (f *innerFile) Insert(p []byte, at int64) int64 {
+// inlineRetains covers the range start, end of the original text with
+// retains of attrs, skipping over newlines.
+func (f *innerFile) inlineRetains(start, end int64, attrs map[string]interface{}) (delta.Delta, error) {
+	data, err := ioutil.ReadAll(f.Reader(start, end))
+	if err != nil {
+		return delta.Delta{}, err
+	}
+	d := delta.New(nil)
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if bytes.HasSuffix(line, []byte("\n")) {
+			d.Retain(len(line)-1, attrs)
+			d.Retain(1, nil)
+		} else {
+			d.Retain(len(line), attrs)
+		}
+	}
+	return *d, nil
+}
+
+// insertLenient and deleteLenient compose every change into one delta, which
+// accepts changes in any order at the cost of surprising results when they
+// overlap.
+func (f *innerFile) insertLenient(d delta.Delta, at int64) int64 {
 	at = int64(f.changes.TransformPosition(int(at), true))
-	if at < 0 || len(p) == 0 {
+	l := textLen(d)
+	if at < 0 || l == 0 {
 		return 0
 	}
 	if at > f.appliedLen {
 		at = f.appliedLen
 	}
-	change := delta.New(nil).Retain(int(at), nil).Insert(string(p), nil)
+	change := delta.New(nil).Retain(int(at), nil).Concat(d)
 	f.updateAppliedLen(change)
 	f.changes = *f.changes.Compose(*change)
-	f.Select(at, at+int64(len(p)))
-	return int64(len(p))
+	f.Select(at, at+l)
+	return l
 }
 
-func (f *innerFile) Delete(start, end int64) int64 {
+func (f *innerFile) deleteLenient(start, end int64) int64 {
 	start = int64(f.changes.TransformPosition(int(start), true))
 	end = int64(f.changes.TransformPosition(int(end), true))
 	if end > f.appliedLen {
@@ -75,12 +479,123 @@ func (f *innerFile) Delete(start, end int64) int64 {
 	return int64(l)
 }
 
+// Contents returns the document behind the range start, end of the original
+// file, the second result is false when the file has no document.
+func (f *innerFile) Contents(start, end int64) (delta.Delta, bool) {
+	rich, ok := f.file.(RichFile)
+	if !ok {
+		return delta.Delta{}, false
+	}
+	if end > f.originalLen {
+		end = f.originalLen
+	}
+	return rich.Contents(f.index.fromByte(start), f.index.fromByte(end)), true
+}
+
+// Text returns the range start, end of the original file as a delta that
+// keeps its attributes and embeds, or as plain text when the file has no
+// document.
+func (f *innerFile) Text(start, end int64) (delta.Delta, error) {
+	if d, ok := f.Contents(start, end); ok {
+		// Pushing onto a delta appends to the runes of its last insert,
+		// which must not write into the file.
+		ops := make([]delta.Op, len(d.Ops))
+		for i, op := range d.Ops {
+			if op.Insert != nil {
+				op.Insert = append([]rune(nil), op.Insert...)
+			}
+			ops[i] = op
+		}
+		return *delta.New(ops), nil
+	}
+	data, err := ioutil.ReadAll(f.Reader(start, end))
+	if err != nil {
+		return delta.Delta{}, err
+	}
+	return *delta.New(nil).Insert(string(data), nil), nil
+}
+
+// inlineAttributes returns the attributes text replacing the range q0, q1
+// takes on: those of the first character replaced, or of the character
+// before q0 when nothing is replaced, like typing in Quill. Line formats
+// kept on newlines and the attributes of embeds are not carried over.
+func (f *innerFile) inlineAttributes(q0, q1 int64) map[string]interface{} {
+	if q1 <= q0 {
+		q0 -= 1
+	}
+	op, ok := f.opAt(q0)
+	if !ok || op.Insert == nil || string(op.Insert) == "\n" {
+		return nil
+	}
+	return op.Attributes
+}
+
+// lineAttributes returns the line format kept on the newline at p.
+func (f *innerFile) lineAttributes(p int64) map[string]interface{} {
+	op, ok := f.opAt(p)
+	if !ok || op.Insert == nil || string(op.Insert) != "\n" {
+		return nil
+	}
+	return op.Attributes
+}
+
+// opAt returns the insert of the original file holding the character at p.
+func (f *innerFile) opAt(p int64) (delta.Op, bool) {
+	if p < 0 || p >= f.originalLen {
+		return delta.Op{}, false
+	}
+	// Positions inside a character count as its start, so p+1 can map back
+	// to p, while the character at p always ends within utf8.UTFMax bytes.
+	end := p + utf8.UTFMax
+	if end > f.originalLen {
+		end = f.originalLen
+	}
+	d, ok := f.Contents(p, end)
+	if !ok || len(d.Ops) == 0 {
+		return delta.Op{}, false
+	}
+	return d.Ops[0], true
+}
+
+// matchRuns returns the ranges within start, end of the original file
+// whose attributes satisfy match, joining neighbouring inserts.
+func (f *innerFile) matchRuns(start, end int64, match func(attrs map[string]interface{}) bool) ([]textRange, error) {
+	d, ok := f.Contents(start, end)
+	if !ok {
+		return nil, fmt.Errorf("File has no attributes!")
+	}
+	runs := make([]textRange, 0)
+	p := start
+	for _, op := range d.Ops {
+		l := textLen(*delta.New([]delta.Op{op}))
+		if match(op.Attributes) {
+			if n := len(runs); n > 0 && runs[n-1].q1 == p {
+				runs[n-1].q1 = p + l
+			} else {
+				runs = append(runs, textRange{q0: p, q1: p + l})
+			}
+		}
+		p += l
+	}
+	return runs, nil
+}
+
 func (f *innerFile) Select(start, end int64) {
-	f.file.Select(start, end)
+	f.dot.q0 = start
+	f.dot.q1 = end
 }
 
 func (f *innerFile) Dot() (int64, int64) {
-	return f.file.Dot()
+	return f.dot.q0, f.dot.q1
+}
+
+func (f *innerFile) SetMark(start, end int64) {
+	f.mark.q0 = start
+	f.mark.q1 = end
+}
+
+func (f *innerFile) Mark() (int64, int64) {
+	return f.mark.q0, f.mark.q1
 }
 
 func (f *innerFile) Len() int64 {
@@ -94,5 +609,5 @@ func (f *innerFile) Reader(start, end int64) io.ReadSeeker {
 	if end > f.Len() {
 		end = f.Len()
 	}
-	return f.file.Reader(start, end)
+	return f.file.Reader(f.index.fromByte(start), f.index.fromByte(end))
 }
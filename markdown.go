@@ -0,0 +1,743 @@
+package editor
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// RenderMarkdown renders the document d as Markdown. Blocks are separated
+// by blank lines, except for the items of a list and the lines of a code
+// block. Formats Markdown has no syntax for, such as colors or alignment,
+// and empty lines are dropped. A last line without newline is only rendered
+// inline, so parts of a line render as the text they hold.
+func RenderMarkdown(d delta.Delta) string {
+	var b strings.Builder
+	var previous map[string]interface{}
+	first := true
+	numbers := make([]int, 0) // numbers of the ordered list items by indent
+	ordered := false          // whether the top level list is ordered
+	for _, line := range splitLines(d) {
+		if !line.ended {
+			b.WriteString(renderMarkdownInline(line.ops, true))
+			continue
+		}
+		code, isCode := line.attrs["code-block"]
+		_, isList := line.attrs["list"]
+		if !isList {
+			numbers = numbers[:0]
+		}
+		if isCode && previous != nil && previous["code-block"] == code {
+			b.WriteString(printableText(delta.Delta{Ops: line.ops}))
+			b.WriteString("\n")
+			continue
+		}
+		if previous != nil && previous["code-block"] != nil {
+			b.WriteString("```\n")
+		}
+		if !isCode && len(line.ops) == 0 {
+			previous = nil
+			continue
+		}
+		sameList := isList && previous != nil && previous["list"] != nil
+		if isList && attributeNumber(line.attrs["indent"]) == 0 {
+			// Markdown starts a new list when bullets turn into numbers
+			sameList = sameList && ordered == (line.attrs["list"] == "ordered")
+			ordered = line.attrs["list"] == "ordered"
+		}
+		if !first && !sameList {
+			b.WriteString("\n")
+		}
+		first = false
+		previous = line.attrs
+		if previous == nil {
+			previous = map[string]interface{}{}
+		}
+		switch {
+		case isCode:
+			language, _ := code.(string)
+			if language == "plain" {
+				language = ""
+			}
+			b.WriteString("```" + language + "\n")
+			b.WriteString(printableText(delta.Delta{Ops: line.ops}))
+			b.WriteString("\n")
+			continue
+		case isList:
+			indent := int(attributeNumber(line.attrs["indent"]))
+			b.WriteString(strings.Repeat("    ", indent))
+			for len(numbers) <= indent {
+				numbers = append(numbers, 0)
+			}
+			numbers = numbers[:indent+1]
+			switch line.attrs["list"] {
+			case "ordered":
+				numbers[indent] += 1
+				fmt.Fprintf(&b, "%d. ", numbers[indent])
+			case "checked":
+				b.WriteString("- [x] ")
+			case "unchecked":
+				b.WriteString("- [ ] ")
+			default:
+				b.WriteString("- ")
+			}
+			if line.attrs["list"] != "ordered" {
+				numbers[indent] = 0
+			}
+		default:
+			if n := attributeNumber(line.attrs["header"]); n >= 1 && n <= 6 {
+				b.WriteString(strings.Repeat("#", int(n)) + " ")
+			} else if line.attrs["blockquote"] == true {
+				b.WriteString("> ")
+			}
+		}
+		b.WriteString(renderMarkdownInline(line.ops, true))
+		b.WriteString("\n")
+	}
+	if previous != nil && previous["code-block"] != nil {
+		b.WriteString("```\n")
+	}
+	return b.String()
+}
+
+// markdownEscaped matches what would be taken as Markdown syntax in text,
+// lineStartEscaped what would only be at the start of a line.
+var (
+	markdownEscaped  = regexp.MustCompile("[\\\\`*_\\[\\]~<]")
+	lineStartEscaped = regexp.MustCompile(`^(#|>|[-+] |\d+[.)] |---)`)
+)
+
+func escapeMarkdown(text string, lineStart bool) string {
+	text = markdownEscaped.ReplaceAllString(text, "\\$0")
+	if lineStart {
+		if m := lineStartEscaped.FindStringIndex(text); m != nil {
+			i := strings.IndexAny(text, "#>-+.)")
+			text = text[:i] + "\\" + text[i:]
+		}
+	}
+	return text
+}
+
+// markdownFormat is an inline format with Markdown syntax, mark is written
+// around the text it applies to.
+type markdownFormat struct {
+	attr, mark string
+}
+
+var markdownFormats = []markdownFormat{
+	{"link", ""},
+	{"bold", "**"},
+	{"italic", "*"},
+	{"strike", "~~"},
+}
+
+func renderMarkdownInline(ops []delta.Op, lineStart bool) string {
+	return renderMarkdownFormats(ops, markdownFormats, lineStart)
+}
+
+// renderMarkdownFormats renders ops with formats. The format running over
+// the most ops is written around all of them at once, so *a **b** c* is not
+// split up.
+func renderMarkdownFormats(ops []delta.Op, formats []markdownFormat, lineStart bool) string {
+	var b strings.Builder
+	for i := 0; i < len(ops); {
+		outer, run := -1, 0
+		for n, format := range formats {
+			value := ops[i].Attributes[format.attr]
+			if value == nil || value == false {
+				continue
+			}
+			j := i + 1
+			for j < len(ops) && ops[j].Attributes[format.attr] == value {
+				j++
+			}
+			if j-i > run {
+				outer, run = n, j-i
+			}
+		}
+		if outer < 0 {
+			b.WriteString(markdownContent(ops[i], lineStart && i == 0))
+			i++
+			continue
+		}
+		format := formats[outer]
+		inner := make([]markdownFormat, 0, len(formats)-1)
+		inner = append(append(inner, formats[:outer]...), formats[outer+1:]...)
+		content := renderMarkdownFormats(ops[i:i+run], inner, lineStart && i == 0)
+		// Emphasis can't start or end with a space, so spaces are kept
+		// outside of it.
+		trimmed := strings.TrimLeft(content, " ")
+		core := strings.TrimRight(trimmed, " ")
+		b.WriteString(content[:len(content)-len(trimmed)])
+		if format.attr == "link" {
+			fmt.Fprintf(&b, "[%s](%s)", core, embedValue(ops[i].Attributes["link"]))
+		} else if core != "" {
+			b.WriteString(format.mark + core + format.mark)
+		}
+		b.WriteString(trimmed[len(core):])
+		i += run
+	}
+	return b.String()
+}
+
+// markdownContent renders the text or embed of op without its formats.
+func markdownContent(op delta.Op, lineStart bool) string {
+	if op.InsertEmbed != nil {
+		value := embedValue(op.InsertEmbed.Value)
+		switch op.InsertEmbed.Key {
+		case "image":
+			alt, _ := op.Attributes["alt"].(string)
+			return fmt.Sprintf("![%s](%s)", escapeMarkdown(alt, false), value)
+		case "divider":
+			return "---"
+		}
+		return fmt.Sprintf("[%s: %s]", op.InsertEmbed.Key, escapeMarkdown(value, false))
+	}
+	if op.Attributes["code"] == true {
+		return codeSpan(string(op.Insert))
+	}
+	return escapeMarkdown(string(op.Insert), lineStart)
+}
+
+// codeSpan quotes text as inline code with more backticks than it holds.
+func codeSpan(text string) string {
+	ticks := "`"
+	for strings.Contains(text, ticks) {
+		ticks += "`"
+	}
+	if len(ticks) > 1 {
+		return ticks + " " + text + " " + ticks
+	}
+	return ticks + text + ticks
+}
+
+var (
+	markdownHeader   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?[ \t]*#*[ \t]*$`)
+	markdownQuote    = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	markdownListItem = regexp.MustCompile(`^( *)([-*+]|\d+[.)])[ \t]+(?:\[([ xX])\][ \t]+)?(.*)$`)
+	markdownFence    = regexp.MustCompile("^ {0,3}```[ \t]*([^` \t]*)")
+	markdownDivider  = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+)
+
+// ParseMarkdown turns Markdown into a Quill document, the common parts of
+// CommonMark: ATX headers, block quotes, nested bullet, ordered and task
+// lists, fenced code blocks, thematic breaks as divider embeds, emphasis,
+// strike through, code spans, links and images. Lines of a paragraph are
+// joined with a space unless they end with a hard line break.
+func ParseMarkdown(src string) delta.Delta {
+	d := delta.New(nil)
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	if n := len(lines); n > 0 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	paragraph := make([]string, 0)
+	var paragraphAttrs map[string]interface{}
+	flush := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		text := ""
+		for i, line := range paragraph {
+			trimmed := strings.TrimRight(line, " \t")
+			hardBreak := strings.HasSuffix(line, "  ") || strings.HasSuffix(trimmed, "\\")
+			trimmed = strings.TrimSuffix(strings.TrimLeft(trimmed, " \t"), "\\")
+			if text != "" && !strings.HasSuffix(text, "\n") {
+				text += " "
+			}
+			text += trimmed
+			if hardBreak && i < len(paragraph)-1 {
+				text += "\n"
+			}
+		}
+		for _, part := range strings.Split(text, "\n") {
+			parseMarkdownInline(d, part, nil)
+			d.Insert("\n", paragraphAttrs)
+		}
+		paragraph = paragraph[:0]
+	}
+	listIndents := make([]int, 0) // leading spaces of the open list items
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if m := markdownFence.FindStringSubmatch(line); m != nil {
+			flush()
+			listIndents = listIndents[:0]
+			var language interface{} = true
+			if m[1] != "" {
+				language = m[1]
+			}
+			for i += 1; i < len(lines) && !markdownFence.MatchString(lines[i]); i++ {
+				d.Insert(lines[i], nil)
+				d.Insert("\n", map[string]interface{}{"code-block": language})
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if markdownDivider.MatchString(line) {
+			flush()
+			listIndents = listIndents[:0]
+			d.InsertEmbed(delta.Embed{Key: "divider", Value: true}, nil)
+			d.Insert("\n", nil)
+			continue
+		}
+		if m := markdownHeader.FindStringSubmatch(line); m != nil {
+			flush()
+			listIndents = listIndents[:0]
+			parseMarkdownInline(d, m[2], nil)
+			d.Insert("\n", map[string]interface{}{"header": len(m[1])})
+			continue
+		}
+		if m := markdownListItem.FindStringSubmatch(line); m != nil {
+			flush()
+			spaces := len(m[1])
+			for len(listIndents) > 0 && listIndents[len(listIndents)-1] >= spaces {
+				listIndents = listIndents[:len(listIndents)-1]
+			}
+			listIndents = append(listIndents, spaces)
+			attrs := map[string]interface{}{"list": "bullet"}
+			if m[2][0] >= '0' && m[2][0] <= '9' {
+				attrs["list"] = "ordered"
+			} else if m[3] == " " {
+				attrs["list"] = "unchecked"
+			} else if m[3] != "" {
+				attrs["list"] = "checked"
+			}
+			if len(listIndents) > 1 {
+				attrs["indent"] = len(listIndents) - 1
+			}
+			// The item is a paragraph of its own, so the lines after it
+			// that start no other block continue it.
+			paragraphAttrs = attrs
+			paragraph = append(paragraph, m[4])
+			continue
+		}
+		if m := markdownQuote.FindStringSubmatch(line); m != nil {
+			if paragraphAttrs["blockquote"] == nil || strings.TrimSpace(m[1]) == "" {
+				flush()
+			}
+			listIndents = listIndents[:0]
+			paragraphAttrs = map[string]interface{}{"blockquote": true}
+			if strings.TrimSpace(m[1]) != "" {
+				paragraph = append(paragraph, m[1])
+			}
+			continue
+		}
+		if paragraphAttrs["list"] != nil && len(paragraph) > 0 {
+			paragraph = append(paragraph, line)
+			continue
+		}
+		if paragraphAttrs != nil {
+			flush()
+		}
+		listIndents = listIndents[:0]
+		paragraphAttrs = nil
+		paragraph = append(paragraph, line)
+	}
+	flush()
+	return *d
+}
+
+// parseMarkdownInline adds the inline Markdown in text to d, with attrs on
+// everything it inserts.
+func parseMarkdownInline(d *delta.Delta, text string, attrs map[string]interface{}) {
+	literal := make([]byte, 0)
+	flush := func() {
+		if len(literal) > 0 {
+			d.Insert(string(literal), attrs)
+			literal = literal[:0]
+		}
+	}
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(markdownPunctuation, text[i+1]) != -1:
+			literal = append(literal, text[i+1])
+			i += 2
+			continue
+		case c == '`':
+			ticks := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			end := strings.Index(text[i+ticks:], text[i:i+ticks])
+			if end >= 0 {
+				flush()
+				code := text[i+ticks : i+ticks+end]
+				if ticks > 1 {
+					code = strings.TrimSpace(code)
+				}
+				d.Insert(code, withAttribute(attrs, "code", true))
+				i += 2*ticks + end
+				continue
+			}
+		case c == '!' && strings.HasPrefix(text[i:], "!["):
+			if alt, src, n := markdownLink(text[i+1:]); n > 0 {
+				flush()
+				imageAttrs := attrs
+				if alt != "" {
+					imageAttrs = withAttribute(attrs, "alt", alt)
+				}
+				d.InsertEmbed(delta.Embed{Key: "image", Value: src}, imageAttrs)
+				i += 1 + n
+				continue
+			}
+		case c == '[':
+			if label, href, n := markdownLink(text[i:]); n > 0 {
+				flush()
+				parseMarkdownInline(d, label, withAttribute(attrs, "link", href))
+				i += n
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if n, inner, formats := markdownEmphasis(text, i); n > 0 {
+				flush()
+				innerAttrs := attrs
+				for _, format := range formats {
+					innerAttrs = withAttribute(innerAttrs, format, true)
+				}
+				parseMarkdownInline(d, inner, innerAttrs)
+				i += n
+				continue
+			}
+		}
+		literal = append(literal, c)
+		i += 1
+	}
+	flush()
+}
+
+const markdownPunctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// markdownLink reads [label](href) at the start of text, it returns how
+// many bytes it takes or 0 when text does not start with a link.
+func markdownLink(text string) (string, string, int) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i += 1
+		case '[':
+			depth += 1
+		case ']':
+			depth -= 1
+			if depth > 0 {
+				continue
+			}
+			if i+1 >= len(text) || text[i+1] != '(' {
+				return "", "", 0
+			}
+			end := strings.IndexByte(text[i+2:], ')')
+			if end < 0 {
+				return "", "", 0
+			}
+			href := strings.TrimSpace(text[i+2 : i+2+end])
+			href = strings.TrimSuffix(strings.TrimPrefix(href, "<"), ">")
+			return text[1:i], href, i + 3 + end
+		}
+	}
+	return "", "", 0
+}
+
+// markdownEmphasis reads emphasis or strike through starting at text[i],
+// returning how many bytes it takes, the text inside and its formats.
+func markdownEmphasis(text string, i int) (int, string, []string) {
+	for _, e := range []struct {
+		mark    string
+		formats []string
+	}{
+		{"~~", []string{"strike"}},
+		{"***", []string{"bold", "italic"}},
+		{"___", []string{"bold", "italic"}},
+		{"**", []string{"bold"}},
+		{"__", []string{"bold"}},
+		{"*", []string{"italic"}},
+		{"_", []string{"italic"}},
+	} {
+		if !strings.HasPrefix(text[i:], e.mark) {
+			continue
+		}
+		start := i + len(e.mark)
+		if start >= len(text) || text[start] == ' ' {
+			continue
+		}
+		if e.mark[0] == '_' && i > 0 && isWordByte(text[i-1]) {
+			continue
+		}
+		end := closingMark(text, start, e.mark)
+		if end < 0 {
+			continue
+		}
+		return end + len(e.mark) - i, text[start:end], e.formats
+	}
+	return 0, "", nil
+}
+
+// closingMark finds the mark closing emphasis opened before start, skipping
+// escapes, code spans and emphasis opened inside of it, as the bold in
+// *a **b** c*.
+func closingMark(text string, start int, mark string) int {
+	openers := make([]int, 0) // lengths of the runs opening inner emphasis
+	for j := start; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j += 1
+			continue
+		case '`':
+			if end := strings.IndexByte(text[j+1:], '`'); end >= 0 {
+				j += end + 1
+			}
+			continue
+		case mark[0]:
+		default:
+			continue
+		}
+		run := len(text[j:]) - len(strings.TrimLeft(text[j:], mark[:1]))
+		afterSpace := j == start || text[j-1] == ' '
+		beforeSpace := j+run >= len(text) || text[j+run] == ' '
+		if !afterSpace {
+			closed := 0
+			for len(openers) > 0 && run-closed >= openers[len(openers)-1] {
+				closed += openers[len(openers)-1]
+				openers = openers[:len(openers)-1]
+			}
+			wordAfter := mark[0] == '_' && !beforeSpace && isWordByte(text[j+run])
+			if len(openers) == 0 && run-closed >= len(mark) && !wordAfter {
+				return j + closed
+			}
+		} else if !beforeSpace {
+			openers = append(openers, run)
+		}
+		j += run - 1
+	}
+	return -1
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// withAttribute returns a copy of attrs with key set to value.
+func withAttribute(attrs map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(attrs)+1)
+	for k, v := range attrs {
+		result[k] = v
+	}
+	result[key] = value
+	return result
+}
+
+// MarkdownFile is a DeltaFile holding a Markdown document, which it saves
+// as Markdown after every change, undo and redo. Only the blocks a change
+// touches are rendered again, the others are saved as they were written,
+// blank lines around them included. A block is a run of lines without a
+// blank line, or a fenced code block as a whole.
+type MarkdownFile struct {
+	*DeltaFile
+	save   func(data []byte) error
+	blocks []markdownBlock
+	tail   string // blank lines after the last block
+	// undoBlocks and redoBlocks hold the blocks before each change undo
+	// and redo of the DeltaFile would revert.
+	undoBlocks, redoBlocks [][]markdownBlock
+}
+
+// markdownBlock is a block of the source together with the range of the
+// document it was parsed into, counting runes like the delta package.
+type markdownBlock struct {
+	sep        string // blank lines before the block
+	src        string
+	changed    bool // src is stale, the block is rendered from the document
+	start, end int64
+}
+
+// NewMarkdownFile parses data, save is called with the document as
+// Markdown whenever it changes.
+func NewMarkdownFile(data []byte, save func(data []byte) error) *MarkdownFile {
+	d := ParseMarkdown(string(data))
+	f := &MarkdownFile{
+		DeltaFile: NewDeltaFile(d),
+		save:      save,
+	}
+	f.blocks, f.tail = splitMarkdownBlocks(string(data))
+	blocks := delta.New(nil)
+	p := int64(0)
+	for i := range f.blocks {
+		b := ParseMarkdown(f.blocks[i].src)
+		blocks = blocks.Concat(b)
+		f.blocks[i].start = p
+		p += int64(b.Length())
+		f.blocks[i].end = p
+	}
+	if !reflect.DeepEqual(blocks.Ops, d.Ops) {
+		// A block depends on the ones before it, as a list continued after
+		// a blank line does, so the source is kept as a whole.
+		f.blocks = []markdownBlock{{
+			src: string(data),
+			end: int64(d.Length()),
+		}}
+		f.tail = ""
+	}
+	return f
+}
+
+// splitMarkdownBlocks returns the blocks of src and the blank lines after
+// the last one.
+func splitMarkdownBlocks(src string) ([]markdownBlock, string) {
+	blocks := make([]markdownBlock, 0)
+	lines := strings.SplitAfter(src, "\n")
+	sep := ""
+	for i := 0; i < len(lines); {
+		if strings.TrimSpace(lines[i]) == "" {
+			sep += lines[i]
+			i++
+			continue
+		}
+		var block strings.Builder
+		for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+			block.WriteString(lines[i])
+			if !markdownFence.MatchString(lines[i]) {
+				continue
+			}
+			for i++; i < len(lines); i++ {
+				block.WriteString(lines[i])
+				if markdownFence.MatchString(lines[i]) {
+					break
+				}
+			}
+		}
+		blocks = append(blocks, markdownBlock{
+			sep: sep,
+			src: block.String(),
+		})
+		sep = ""
+	}
+	return blocks, sep
+}
+
+// Markdown returns the document as Markdown.
+func (f *MarkdownFile) Markdown() []byte {
+	var b strings.Builder
+	for _, block := range f.blocks {
+		text := block.src
+		if block.changed {
+			text = RenderMarkdown(*f.Delta.Slice(int(block.start), int(block.end)))
+			if text == "" {
+				continue
+			}
+		}
+		b.WriteString(block.sep)
+		b.WriteString(text)
+	}
+	b.WriteString(f.tail)
+	return []byte(b.String())
+}
+
+// transformBlocks moves the blocks along with the change d, counting runes.
+// The blocks d changes are marked as such and merged with their changed
+// neighbours, text inserted between two blocks goes to the first.
+func (f *MarkdownFile) transformBlocks(d delta.Delta) {
+	blocks := append([]markdownBlock(nil), f.blocks...)
+	touch := func(q0, q1 int64) {
+		for i := range blocks {
+			b := &blocks[i]
+			if (q0 < b.end && b.start < q1) || (q0 == q1 && (b.start < q0 || q0 == 0) && q0 <= b.end) {
+				b.changed = true
+				if q0 == q1 {
+					return
+				}
+			}
+		}
+	}
+	p := int64(0)
+	for _, op := range d.Ops {
+		n := int64(op.Length())
+		switch {
+		case op.Retain != nil:
+			if op.Attributes != nil {
+				touch(p, p+n)
+			}
+			p += n
+		case op.Delete != nil:
+			touch(p, p+n)
+			p += n
+		default:
+			touch(p, p)
+		}
+	}
+	f.blocks = make([]markdownBlock, 0, len(blocks))
+	start := int64(0)
+	for _, b := range blocks {
+		b.start = start
+		b.end = int64(d.TransformPosition(int(b.end), false))
+		start = b.end
+		if n := len(f.blocks); n > 0 && b.changed && f.blocks[n-1].changed {
+			f.blocks[n-1].end = b.end
+			continue
+		}
+		f.blocks = append(f.blocks, b)
+	}
+	if l := int64(f.Delta.Length()); start < l {
+		f.blocks = append(f.blocks, markdownBlock{
+			changed: true,
+			start:   start,
+			end:     l,
+		})
+	}
+}
+
+// Compose applies d and saves the result, d is undone again when saving
+// fails.
+func (f *MarkdownFile) Compose(d delta.Delta) error {
+	err := f.DeltaFile.Compose(d)
+	if err != nil || len(d.Ops) == 0 {
+		return err
+	}
+	blocks := f.blocks
+	f.transformBlocks(f.undo[len(f.undo)-1].change)
+	err = f.save(f.Markdown())
+	if err != nil {
+		f.DeltaFile.Undo()
+		f.redo = f.redo[:len(f.redo)-1]
+		f.blocks = blocks
+		return err
+	}
+	f.undoBlocks = append(f.undoBlocks, blocks)
+	f.redoBlocks = nil
+	return nil
+}
+
+// Undo and Redo return false when the result can't be saved, leaving the
+// document as it was.
+func (f *MarkdownFile) Undo() bool {
+	if !f.DeltaFile.Undo() {
+		return false
+	}
+	blocks := f.blocks
+	f.blocks = f.undoBlocks[len(f.undoBlocks)-1]
+	if f.save(f.Markdown()) != nil {
+		f.DeltaFile.Redo()
+		f.blocks = blocks
+		return false
+	}
+	f.undoBlocks = f.undoBlocks[:len(f.undoBlocks)-1]
+	f.redoBlocks = append(f.redoBlocks, blocks)
+	return true
+}
+
+func (f *MarkdownFile) Redo() bool {
+	if !f.DeltaFile.Redo() {
+		return false
+	}
+	blocks := f.blocks
+	f.blocks = f.redoBlocks[len(f.redoBlocks)-1]
+	if f.save(f.Markdown()) != nil {
+		f.DeltaFile.Undo()
+		f.blocks = blocks
+		return false
+	}
+	f.redoBlocks = f.redoBlocks[:len(f.redoBlocks)-1]
+	f.undoBlocks = append(f.undoBlocks, blocks)
+	return true
+}
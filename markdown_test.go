@@ -0,0 +1,138 @@
+package editor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	names, err := filepath.Glob(filepath.Join("testdata", "markdown", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := ParseMarkdown(string(src))
+		data, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, strings.TrimSuffix(name, ".md")+".json", string(data)+"\n")
+		if actual := RenderMarkdown(d); actual != string(src) {
+			t.Fatalf("Invalid result for %s, expected: \"%s\", actual: \"%s\"", name, string(src), actual)
+		}
+	}
+}
+
+func TestParseMarkdown(t *testing.T) {
+	bold := map[string]interface{}{"bold": true}
+	d := ParseMarkdown("A paragraph\nsoftly wrapped  \nwith a **hard\nbreak**\n* star item\n")
+	expected := *delta.New(nil).Insert("A paragraph softly wrapped\nwith a ", nil).
+		Insert("hard break", bold).
+		Insert("\nstar item", nil).
+		Insert("\n", map[string]interface{}{"list": "bullet"})
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("Invalid document, expected: %s, actual: %s",
+			debugDeltaString(t, expected), debugDeltaString(t, d))
+	}
+}
+
+func TestMarkdownListContinuation(t *testing.T) {
+	bullet := map[string]interface{}{"list": "bullet"}
+	expected := *delta.New(nil).Insert("item continued", nil).
+		Insert("\n", bullet).
+		Insert("lazy one", nil).
+		Insert("\n", bullet).
+		Insert("> not quoted", nil).
+		Insert("\n", nil)
+	d := ParseMarkdown("- item\n  continued\n- lazy\none\n\n\\> not quoted\n")
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("Invalid document, expected: %s, actual: %s",
+			debugDeltaString(t, expected), debugDeltaString(t, d))
+	}
+	rendered := RenderMarkdown(d)
+	if rendered != "- item continued\n- lazy one\n\n\\> not quoted\n" {
+		t.Fatalf("Invalid Markdown: \"%s\"", rendered)
+	}
+	if again := ParseMarkdown(rendered); !reflect.DeepEqual(again, expected) {
+		t.Fatalf("Invalid round trip: %s", debugDeltaString(t, again))
+	}
+}
+
+func TestMarkdownFile(t *testing.T) {
+	saved := ""
+	fail := false
+	f := NewMarkdownFile([]byte("# TODO list\n\nFix the TODO items\n"), func(data []byte) error {
+		if fail {
+			return fmt.Errorf("disk full")
+		}
+		saved = string(data)
+		return nil
+	})
+	err := run(",x/TODO/ fmt bold", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# **TODO** list\n\nFix the **TODO** items\n"
+	if saved != expected {
+		t.Fatalf("Invalid saved Markdown, expected: \"%s\", actual: \"%s\"", expected, saved)
+	}
+	fail = true
+	if err := run(",d", f); err == nil {
+		t.Fatal("Expected saving to fail")
+	}
+	if string(f.Markdown()) != expected {
+		t.Fatalf("Failed change was not undone: \"%s\"", string(f.Markdown()))
+	}
+	if f.Undo() {
+		t.Fatal("Expected undo to fail")
+	}
+	fail = false
+	if !f.Undo() || saved != "# TODO list\n\nFix the TODO items\n" {
+		t.Fatalf("Invalid saved Markdown after undo: \"%s\"", saved)
+	}
+}
+
+func TestMarkdownFileKeepsSource(t *testing.T) {
+	src := "Title\n=====\n\n* one\n* two\n\n\n__bold__ text\nwrapped\n\n```\ncode\n\n  more\n```\n\nEdit me\n\n"
+	saved := ""
+	f := NewMarkdownFile([]byte(src), func(data []byte) error {
+		saved = string(data)
+		return nil
+	})
+	if string(f.Markdown()) != src {
+		t.Fatalf("Source not kept: \"%s\"", string(f.Markdown()))
+	}
+	err := run(",x/me/c/you/", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Replace(src, "Edit me", "Edit you", 1)
+	if saved != expected {
+		t.Fatalf("Invalid saved Markdown, expected: \"%s\", actual: \"%s\"", expected, saved)
+	}
+	err = run("/two/c/2/", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = strings.Replace(expected, "* one\n* two", "- one\n- 2", 1)
+	if saved != expected {
+		t.Fatalf("Invalid saved Markdown, expected: \"%s\", actual: \"%s\"", expected, saved)
+	}
+	if !f.Undo() || !f.Undo() || saved != src {
+		t.Fatalf("Invalid saved Markdown after undo: \"%s\"", saved)
+	}
+	if !f.Redo() || saved != strings.Replace(src, "Edit me", "Edit you", 1) {
+		t.Fatalf("Invalid saved Markdown after redo: \"%s\"", saved)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package editor
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps size bytes of file into memory, returning the mapping and
+// a function releasing it.
+func mmapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	if size == 0 || int64(int(size)) != size {
+		return nil, func() error { return nil }, nil
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error {
+		return syscall.Munmap(data)
+	}, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package editor
+
+import "os"
+
+// mmapFile does not map anything here, the file is read with ReadAt.
+func mmapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	return nil, func() error { return nil }, nil
+}
@@ -0,0 +1,137 @@
+package editor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// PrintFormat is how the p command prints the text it addresses.
+type PrintFormat int
+
+const (
+	// PrintText prints the plain text, with placeholders for embeds.
+	PrintText PrintFormat = iota
+	// PrintDelta prints the Delta JSON of the text.
+	PrintDelta
+	PrintHTML
+	PrintMarkdown
+)
+
+func (p PrintFormat) String() string {
+	switch p {
+	case PrintText:
+		return "text"
+	case PrintDelta:
+		return "delta"
+	case PrintHTML:
+		return "html"
+	case PrintMarkdown:
+		return "md"
+	}
+	return fmt.Sprintf("format %d", int(p))
+}
+
+// parsePrintFormat reads the argument of p, an empty one keeps format.
+func parsePrintFormat(name string, format PrintFormat) (PrintFormat, error) {
+	switch name {
+	case "":
+		return format, nil
+	case "text":
+		return PrintText, nil
+	case "delta", "json":
+		return PrintDelta, nil
+	case "html":
+		return PrintHTML, nil
+	case "md", "markdown":
+		return PrintMarkdown, nil
+	}
+	return format, fmt.Errorf("Unknown print format %s", name)
+}
+
+// formatDelta renders the document d in format.
+func formatDelta(d delta.Delta, format PrintFormat) (string, error) {
+	switch format {
+	case PrintDelta:
+		data, err := json.Marshal(d)
+		if err != nil {
+			return "", err
+		}
+		return string(data) + "\n", nil
+	case PrintHTML:
+		return RenderHTML(d), nil
+	case PrintMarkdown:
+		return RenderMarkdown(d), nil
+	}
+	return printableText(d), nil
+}
+
+// printableText renders the text of d, showing each embed as a placeholder
+// like [image: https://example.com/a.png].
+func printableText(d delta.Delta) string {
+	var b strings.Builder
+	for _, op := range d.Ops {
+		if op.Insert != nil {
+			b.WriteString(string(op.Insert))
+		} else if op.InsertEmbed != nil {
+			fmt.Fprintf(&b, "[%s: %s]", op.InsertEmbed.Key, embedValue(op.InsertEmbed.Value))
+		}
+	}
+	return b.String()
+}
+
+// embedValue returns the value of an embed as a string, which is its JSON
+// unless it is a string already.
+func embedValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// docLine is a line of a document, Quill keeps its line format on the
+// newline ending it. The last line of a part of a document may have no
+// newline, ended is false then.
+type docLine struct {
+	ops   []delta.Op
+	attrs map[string]interface{}
+	ended bool
+}
+
+func splitLines(d delta.Delta) []docLine {
+	lines := make([]docLine, 0)
+	var line docLine
+	for _, op := range d.Ops {
+		if op.Insert == nil {
+			if op.InsertEmbed != nil {
+				line.ops = append(line.ops, op)
+			}
+			continue
+		}
+		parts := strings.Split(string(op.Insert), "\n")
+		for i, part := range parts {
+			if i > 0 {
+				line.attrs = op.Attributes
+				line.ended = true
+				lines = append(lines, line)
+				line = docLine{}
+			}
+			if part != "" {
+				line.ops = append(line.ops, delta.Op{
+					Insert:     []rune(part),
+					Attributes: op.Attributes,
+				})
+			}
+		}
+	}
+	if len(line.ops) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
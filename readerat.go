@@ -0,0 +1,127 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// ReaderAtFile is a read only File over an io.ReaderAt. Each of its readers
+// keeps its own offset, so they can be used at the same time, also from
+// different goroutines when the io.ReaderAt allows it as *os.File does.
+type ReaderAtFile struct {
+	r    io.ReaderAt
+	size int64
+	// data holds the whole file when it is memory mapped, readers then
+	// work on it directly without any system call.
+	data       []byte
+	close      func() error
+	start, end int64
+}
+
+func NewReaderAtFile(r io.ReaderAt, size int64) *ReaderAtFile {
+	return &ReaderAtFile{
+		r:    r,
+		size: size,
+	}
+}
+
+// OpenReaderAtFile opens the named file, read with ReadAt. Close releases
+// it.
+func OpenReaderAtFile(name string) (*ReaderAtFile, error) {
+	return openReaderAtFile(name, false)
+}
+
+// OpenMappedReaderAtFile opens the named file memory mapped on systems that
+// support it, and read with ReadAt elsewhere. Reading a mapped file that
+// another process truncates crashes the program with SIGBUS, so only map
+// files nothing else changes while they are open. Close releases it.
+func OpenMappedReaderAtFile(name string) (*ReaderAtFile, error) {
+	return openReaderAtFile(name, true)
+}
+
+func openReaderAtFile(name string, mmap bool) (*ReaderAtFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f := NewReaderAtFile(file, stat.Size())
+	unmap := func() error { return nil }
+	if mmap {
+		var data []byte
+		data, unmap, err = mmapFile(file, stat.Size())
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if data != nil {
+			f.data = data
+			f.r = bytes.NewReader(data)
+		}
+	}
+	f.close = func() error {
+		err := unmap()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return f, nil
+}
+
+// Close releases the file opened by OpenReaderAtFile or
+// OpenMappedReaderAtFile, readers must not be
+// used afterwards. It does nothing for a ReaderAtFile made by
+// NewReaderAtFile.
+func (f *ReaderAtFile) Close() error {
+	if f.close == nil {
+		return nil
+	}
+	release := f.close
+	f.close = nil
+	f.data = nil
+	return release()
+}
+
+func (f *ReaderAtFile) Select(start, end int64) {
+	f.start = start
+	f.end = end
+}
+
+func (f *ReaderAtFile) Dot() (start, end int64) {
+	start = f.start
+	end = f.end
+	return
+}
+
+func (f *ReaderAtFile) Len() (int64, error) {
+	return f.size, nil
+}
+
+func (f *ReaderAtFile) Reader(start, end int64) io.ReadSeeker {
+	if end < start || start > f.size {
+		return nil
+	}
+	if end > f.size {
+		end = f.size
+	}
+	if f.data != nil {
+		return bytes.NewReader(f.data[start:end])
+	}
+	return io.NewSectionReader(f.r, start, end-start)
+}
+
+func (f *ReaderAtFile) Compose(d delta.Delta) error {
+	if len(d.Ops) > 0 {
+		return fmt.Errorf("ReaderAtFile is read only!")
+	}
+	return nil
+}
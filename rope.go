@@ -0,0 +1,439 @@
+package editor
+
+import (
+	"io"
+	"math/rand"
+	"unicode/utf8"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// RopeFile is a File holding a Quill document in a rope, a balanced tree of
+// pieces of text and embeds, so reading and editing a large document take
+// O(log n) instead of going over all of it like DeltaFile does. The tree is
+// never changed in place: each Compose builds a new one sharing what did
+// not change, which makes readers and undo cheap.
+type RopeFile struct {
+	root       *ropeNode
+	unit       Unit
+	start, end int64
+	undo, redo []ropeHistoryEntry
+}
+
+// ropeHistoryEntry keeps the trees before and after a composed delta.
+type ropeHistoryEntry struct {
+	before, after *ropeNode
+}
+
+// maxPiece is the most bytes of text a single piece holds, so splitting one
+// never costs more than a constant.
+const maxPiece = 512
+
+// ropePiece is a run of text with the same attributes, or a single embed.
+type ropePiece struct {
+	text   string
+	embed  *delta.Embed
+	attrs  map[string]interface{}
+	units  int64
+	nbytes int64 // embeds read as a single 0 byte
+}
+
+type ropeNode struct {
+	left, right *ropeNode
+	priority    uint32
+	piece       ropePiece
+	// Lengths of the whole subtree.
+	units, nbytes int64
+}
+
+func NewRopeFile(d delta.Delta) *RopeFile {
+	return NewRopeFileUnit(d, UTF16)
+}
+
+// NewRopeFileUnit returns a RopeFile holding the inserts of d, with
+// positions counted in unit.
+func NewRopeFileUnit(d delta.Delta, unit Unit) *RopeFile {
+	f := &RopeFile{
+		unit: unit,
+	}
+	for _, op := range d.Ops {
+		f.root = ropeMerge(f.root, f.build(op))
+	}
+	return f
+}
+
+func (f *RopeFile) Unit() Unit {
+	return f.unit
+}
+
+// Delta returns the document held by the file.
+func (f *RopeFile) Delta() delta.Delta {
+	return ropeDelta(f.root)
+}
+
+func (f *RopeFile) Select(start, end int64) {
+	f.start = start
+	f.end = end
+}
+
+func (f *RopeFile) Dot() (start, end int64) {
+	start = f.start
+	end = f.end
+	return
+}
+
+func (f *RopeFile) Len() (int64, error) {
+	return f.root.unitLen(), nil
+}
+
+func (f *RopeFile) Reader(start, end int64) io.ReadSeeker {
+	l := f.root.unitLen()
+	if end < start || start > l {
+		return nil
+	}
+	if end > l {
+		end = l
+	}
+	return &ropeReader{
+		root:  f.root,
+		start: f.byteOffset(start),
+		end:   f.byteOffset(end),
+	}
+}
+
+func (f *RopeFile) Contents(start, end int64) delta.Delta {
+	_, right := f.split(f.root, start)
+	middle, _ := f.split(right, end-start)
+	return ropeDelta(middle)
+}
+
+// Compose applies d, whose retains and deletes count the unit of the file.
+// It only takes O(log n) for each op, apart from retains with attributes
+// which go over the pieces they format.
+func (f *RopeFile) Compose(d delta.Delta) error {
+	if len(d.Ops) == 0 {
+		return nil
+	}
+	before := f.root
+	var result *ropeNode
+	rest := f.root
+	q0, q1 := int64(0), int64(0)
+	for i, op := range d.Ops {
+		switch {
+		case op.Retain != nil:
+			var retained *ropeNode
+			retained, rest = f.split(rest, int64(*op.Retain))
+			if op.Attributes != nil {
+				retained = ropeFormat(retained, op.Attributes)
+			} else if i == 0 {
+				q0 = retained.unitLen()
+			}
+			result = ropeMerge(result, retained)
+			q1 = result.unitLen()
+		case op.Delete != nil:
+			_, rest = f.split(rest, int64(*op.Delete))
+		default:
+			result = ropeMerge(result, f.build(op))
+			q1 = result.unitLen()
+		}
+	}
+	f.root = ropeMerge(result, rest)
+	f.undo = append(f.undo, ropeHistoryEntry{
+		before: before,
+		after:  f.root,
+	})
+	f.redo = nil
+	if q1 < q0 {
+		q1 = q0
+	}
+	f.Select(q0, q1)
+	return nil
+}
+
+// Undo reverts the last composed delta not undone yet, it returns false when
+// there is nothing left to undo.
+func (f *RopeFile) Undo() bool {
+	if len(f.undo) == 0 {
+		return false
+	}
+	entry := f.undo[len(f.undo)-1]
+	f.undo = f.undo[:len(f.undo)-1]
+	f.redo = append(f.redo, entry)
+	f.root = entry.before
+	return true
+}
+
+// Redo composes again the last delta reverted by Undo, it returns false when
+// there is nothing to redo.
+func (f *RopeFile) Redo() bool {
+	if len(f.redo) == 0 {
+		return false
+	}
+	entry := f.redo[len(f.redo)-1]
+	f.redo = f.redo[:len(f.redo)-1]
+	f.undo = append(f.undo, entry)
+	f.root = entry.after
+	return true
+}
+
+// build returns a tree of the insert op, with its text cut into pieces of
+// at most maxPiece bytes.
+func (f *RopeFile) build(op delta.Op) *ropeNode {
+	if op.InsertEmbed != nil {
+		embed := *op.InsertEmbed
+		return newRopeNode(ropePiece{
+			embed:  &embed,
+			attrs:  op.Attributes,
+			units:  1,
+			nbytes: 1,
+		})
+	}
+	if op.Insert == nil {
+		return nil
+	}
+	var n *ropeNode
+	text := string(op.Insert)
+	for text != "" {
+		i := len(text)
+		if i > maxPiece {
+			i = maxPiece
+			for i > 0 && !utf8.RuneStart(text[i]) {
+				i--
+			}
+		}
+		n = ropeMerge(n, newRopeNode(f.textPiece(text[:i], op.Attributes)))
+		text = text[i:]
+	}
+	return n
+}
+
+func (f *RopeFile) textPiece(text string, attrs map[string]interface{}) ropePiece {
+	return ropePiece{
+		text:   text,
+		attrs:  attrs,
+		units:  unitLen(text, f.unit),
+		nbytes: int64(len(text)),
+	}
+}
+
+// split returns the trees of the first p units of n and of the rest. A
+// character counting more than one unit is not split, p then ends before
+// it.
+func (f *RopeFile) split(n *ropeNode, p int64) (*ropeNode, *ropeNode) {
+	if n == nil {
+		return nil, nil
+	}
+	leftLen := n.left.unitLen()
+	switch {
+	case p <= leftLen:
+		left, right := f.split(n.left, p)
+		c := *n
+		c.left = right
+		return left, c.update()
+	case p >= leftLen+n.piece.units:
+		left, right := f.split(n.right, p-leftLen-n.piece.units)
+		c := *n
+		c.right = left
+		return c.update(), right
+	}
+	i := f.pieceOffset(n.piece, p-leftLen)
+	left, right := n.left, n.right
+	if i > 0 {
+		left = ropeMerge(left, newRopeNode(f.textPiece(n.piece.text[:i], n.piece.attrs)))
+	}
+	if i < len(n.piece.text) {
+		right = ropeMerge(newRopeNode(f.textPiece(n.piece.text[i:], n.piece.attrs)), right)
+	}
+	return left, right
+}
+
+// pieceOffset returns the byte offset of position p of a text piece.
+func (f *RopeFile) pieceOffset(piece ropePiece, p int64) int {
+	u := int64(0)
+	for i := 0; i < len(piece.text); {
+		r, size := utf8.DecodeRuneInString(piece.text[i:])
+		u += runeLen(r, size, f.unit)
+		if u > p {
+			return i
+		}
+		i += size
+	}
+	return len(piece.text)
+}
+
+// byteOffset returns the byte offset of position p in the text Reader
+// returns.
+func (f *RopeFile) byteOffset(p int64) int64 {
+	offset := int64(0)
+	for n := f.root; n != nil; {
+		leftLen := n.left.unitLen()
+		switch {
+		case p < leftLen:
+			n = n.left
+		case p < leftLen+n.piece.units:
+			offset += n.left.byteLen()
+			if n.piece.embed != nil {
+				return offset
+			}
+			return offset + int64(f.pieceOffset(n.piece, p-leftLen))
+		default:
+			offset += n.left.byteLen() + n.piece.nbytes
+			p -= leftLen + n.piece.units
+			n = n.right
+		}
+	}
+	return offset
+}
+
+func newRopeNode(piece ropePiece) *ropeNode {
+	n := &ropeNode{
+		priority: rand.Uint32(),
+		piece:    piece,
+	}
+	return n.update()
+}
+
+func (n *ropeNode) unitLen() int64 {
+	if n == nil {
+		return 0
+	}
+	return n.units
+}
+
+func (n *ropeNode) byteLen() int64 {
+	if n == nil {
+		return 0
+	}
+	return n.nbytes
+}
+
+// update sets the lengths of n from its children, returning n.
+func (n *ropeNode) update() *ropeNode {
+	n.units = n.left.unitLen() + n.piece.units + n.right.unitLen()
+	n.nbytes = n.left.byteLen() + n.piece.nbytes + n.right.byteLen()
+	return n
+}
+
+// ropeMerge returns the tree of a followed by b.
+func ropeMerge(a, b *ropeNode) *ropeNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		c := *a
+		c.right = ropeMerge(a.right, b)
+		return c.update()
+	}
+	c := *b
+	c.left = ropeMerge(a, b.left)
+	return c.update()
+}
+
+// ropeFormat returns n with attrs composed into the attributes of each of
+// its pieces.
+func ropeFormat(n *ropeNode, attrs map[string]interface{}) *ropeNode {
+	if n == nil {
+		return nil
+	}
+	c := *n
+	c.left = ropeFormat(n.left, attrs)
+	c.right = ropeFormat(n.right, attrs)
+	c.piece.attrs = delta.AttrCompose(n.piece.attrs, attrs, false)
+	return &c
+}
+
+// ropeDelta returns the document held by n.
+func ropeDelta(n *ropeNode) delta.Delta {
+	d := delta.New(nil)
+	var walk func(n *ropeNode)
+	walk = func(n *ropeNode) {
+		if n == nil {
+			return
+		}
+		walk(n.left)
+		if n.piece.embed != nil {
+			d.InsertEmbed(*n.piece.embed, n.piece.attrs)
+		} else {
+			d.Insert(n.piece.text, n.piece.attrs)
+		}
+		walk(n.right)
+	}
+	walk(n)
+	return *d
+}
+
+// ropeReader reads the bytes start to end of a tree, later changes to the
+// file do not affect it.
+type ropeReader struct {
+	root       *ropeNode
+	start, end int64
+	offset     int64
+}
+
+func (r *ropeReader) Read(p []byte) (int, error) {
+	read := 0
+	for read < len(p) {
+		pos := r.start + r.offset
+		if pos >= r.end {
+			break
+		}
+		piece, i := ropePieceAt(r.root, pos)
+		var data string
+		if piece.embed != nil {
+			data = "\x00"
+		} else {
+			data = piece.text[i:]
+		}
+		if remaining := r.end - pos; int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+		if data == "" {
+			break
+		}
+		n := copy(p[read:], data)
+		read += n
+		r.offset += int64(n)
+	}
+	if read == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return read, nil
+}
+
+func (r *ropeReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = r.offset + offset
+	case io.SeekEnd:
+		newOffset = r.end - r.start + offset
+	}
+	if newOffset < 0 {
+		return -1, io.EOF
+	}
+	r.offset = newOffset
+	return r.offset, nil
+}
+
+// ropePieceAt returns the piece holding byte offset p of n, and the offset
+// of p within it.
+func ropePieceAt(n *ropeNode, p int64) (ropePiece, int64) {
+	for n != nil {
+		leftLen := n.left.byteLen()
+		switch {
+		case p < leftLen:
+			n = n.left
+		case p < leftLen+n.piece.nbytes:
+			return n.piece, p - leftLen
+		default:
+			p -= leftLen + n.piece.nbytes
+			n = n.right
+		}
+	}
+	return ropePiece{}, 0
+}
@@ -0,0 +1,104 @@
+package editor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+func TestRopeFile(t *testing.T) {
+	bold := map[string]interface{}{"bold": true}
+	var b strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&b, "line %d with 😀 and wörds\n", i)
+	}
+	content := *delta.New(nil).Insert("Title", bold).
+		Insert("\n", map[string]interface{}{"header": 1}).
+		Insert(b.String(), nil).
+		InsertEmbed(delta.Embed{Key: "image", Value: "a.png"}, nil).
+		Insert("\n", nil)
+	for _, unit := range []Unit{UTF16, Rune, Byte} {
+		d := NewDeltaFileUnit(content, unit)
+		r := NewRopeFileUnit(content, unit)
+		for _, command := range []string{
+			",x/wörds/c/words/",
+			",x/[0-9]+5 /fmt italic",
+			"/line 17 /,/line 150 /d",
+			"/😀/a/ 🎉/",
+			"$-/Title/fmt -bold",
+			"1lfmt header=2",
+			"/line 190/embed formula x",
+			",x/\\n/s/$/;/",
+		} {
+			for _, f := range []File{d, r} {
+				err := run(command, f)
+				if err != nil {
+					t.Fatalf("%s in %s: %v", command, unit, err)
+				}
+			}
+			if !reflect.DeepEqual(d.Delta, r.Delta()) {
+				t.Fatalf("%s in %s: invalid content, expected: %s, actual: %s", command, unit,
+					debugDeltaString(t, d.Delta), debugDeltaString(t, r.Delta()))
+			}
+			dl, _ := d.Len()
+			rl, _ := r.Len()
+			if dl != rl {
+				t.Fatalf("%s in %s: invalid length, expected: %d, actual: %d", command, unit, dl, rl)
+			}
+			q0, q1 := r.Dot()
+			data, err := ioutil.ReadAll(r.Reader(q0, q1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, _ := ioutil.ReadAll(d.Reader(q0, q1))
+			if string(data) != string(expected) {
+				t.Fatalf("%s in %s: invalid dot text, expected: %q, actual: %q", command, unit, expected, data)
+			}
+		}
+		if !reflect.DeepEqual(d.Contents(10, 300), r.Contents(10, 300)) {
+			t.Fatalf("Invalid contents in %s, expected: %s, actual: %s", unit,
+				debugDeltaString(t, d.Contents(10, 300)), debugDeltaString(t, r.Contents(10, 300)))
+		}
+	}
+}
+
+func TestRopeFileUndo(t *testing.T) {
+	content := *delta.New(nil).Insert("one two three\n", nil)
+	r := NewRopeFile(content)
+	reader := r.Reader(0, 13)
+	for _, command := range []string{",x/two/c/2/", "/three/fmt bold"} {
+		err := run(command, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one two three" {
+		t.Fatalf("Reader changed along with the file: \"%s\"", string(data))
+	}
+	changed := *delta.New(nil).Insert("one 2 ", nil).
+		Insert("three", map[string]interface{}{"bold": true}).
+		Insert("\n", nil)
+	if !reflect.DeepEqual(r.Delta(), changed) {
+		t.Fatalf("Invalid content: %s", debugDeltaString(t, r.Delta()))
+	}
+	if !r.Undo() || !r.Undo() || r.Undo() {
+		t.Fatal("Expected exactly two changes to undo")
+	}
+	if !reflect.DeepEqual(r.Delta(), content) {
+		t.Fatalf("Invalid content after undo: %s", debugDeltaString(t, r.Delta()))
+	}
+	if !r.Redo() || !r.Redo() || r.Redo() {
+		t.Fatal("Expected exactly two changes to redo")
+	}
+	if !reflect.DeepEqual(r.Delta(), changed) {
+		t.Fatalf("Invalid content after redo: %s", debugDeltaString(t, r.Delta()))
+	}
+}
@@ -0,0 +1,72 @@
+package editor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Runner runs the external commands used by <, >, | and !, and keeps the
+// working directory changed by cd.
+type Runner interface {
+	// Run runs command reading stdin as its input, stdin might be nil when
+	// the command takes no input.
+	Run(command string, stdin io.Reader, stdout, stderr io.Writer) error
+	Chdir(dir string) error
+}
+
+// ExecRunner runs commands through a shell using os/exec, it is the Runner
+// used when Context.Runner is not set.
+type ExecRunner struct {
+	// Shell defaults to /bin/sh, commands are passed to it with -c.
+	Shell string
+	// Dir is the directory commands run in, empty means the current
+	// directory of the process.
+	Dir string
+}
+
+func (r *ExecRunner) Run(command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	shell := r.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	c := exec.Command(shell, "-c", command)
+	c.Dir = r.Dir
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+	return c.Run()
+}
+
+// Chdir changes the directory of later commands, like sam an empty dir
+// means the home directory.
+func (r *ExecRunner) Chdir(dir string) error {
+	var err error
+	if dir == "" {
+		dir, err = os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+	}
+	if !filepath.IsAbs(dir) {
+		base := r.Dir
+		if base == "" {
+			base, err = os.Getwd()
+			if err != nil {
+				return err
+			}
+		}
+		dir = filepath.Join(base, dir)
+	}
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory!", dir)
+	}
+	r.Dir = dir
+	return nil
+}
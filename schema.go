@@ -0,0 +1,198 @@
+package editor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// Validator checks the value of a format or embed, returning why it is not
+// allowed.
+type Validator func(value interface{}) error
+
+// Schema is a registry of the formats and embeds a document may hold. When
+// Context.Schema is set, a command whose changes put anything else into a
+// File fails before the File composes them. Removing a format, by setting
+// it to nil, is always allowed.
+type Schema struct {
+	// Inline formats apply to text, Block formats to the newline ending a
+	// line as Quill keeps line formats there.
+	Inline map[string]Validator
+	Block  map[string]Validator
+	// Embeds validates the value of each embed type, EmbedFormats the
+	// formats embeds take besides the inline ones, such as the alt of an
+	// image.
+	Embeds       map[string]Validator
+	EmbedFormats map[string]Validator
+}
+
+func NewSchema() *Schema {
+	return &Schema{
+		Inline:       make(map[string]Validator),
+		Block:        make(map[string]Validator),
+		Embeds:       make(map[string]Validator),
+		EmbedFormats: make(map[string]Validator),
+	}
+}
+
+// QuillSchema returns a Schema of the formats and embeds Quill supports out
+// of the box.
+func QuillSchema() *Schema {
+	s := NewSchema()
+	for _, name := range []string{"bold", "italic", "underline", "strike", "code"} {
+		s.Inline[name] = ValidateTrue
+	}
+	for _, name := range []string{"link", "color", "background", "font", "size"} {
+		s.Inline[name] = ValidateString
+	}
+	s.Inline["script"] = ValidateOneOf("sub", "super")
+	s.Block["header"] = ValidateRange(1, 6)
+	s.Block["list"] = ValidateOneOf("ordered", "bullet", "checked", "unchecked")
+	s.Block["blockquote"] = ValidateTrue
+	s.Block["code-block"] = func(value interface{}) error {
+		if value == true {
+			return nil
+		}
+		return ValidateString(value)
+	}
+	s.Block["indent"] = ValidateRange(1, 8)
+	s.Block["align"] = ValidateOneOf("center", "right", "justify")
+	s.Block["direction"] = ValidateOneOf("rtl")
+	for _, name := range []string{"image", "video", "formula"} {
+		s.Embeds[name] = ValidateString
+	}
+	for _, name := range []string{"alt", "width", "height"} {
+		s.EmbedFormats[name] = ValidateString
+	}
+	return s
+}
+
+func ValidateTrue(value interface{}) error {
+	if value != true {
+		return fmt.Errorf("must be true")
+	}
+	return nil
+}
+
+func ValidateString(value interface{}) error {
+	if _, ok := value.(string); !ok {
+		return fmt.Errorf("must be a string")
+	}
+	return nil
+}
+
+// ValidateOneOf allows the given values only.
+func ValidateOneOf(values ...interface{}) Validator {
+	return func(value interface{}) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		names := make([]string, 0, len(values))
+		for _, v := range values {
+			names = append(names, fmt.Sprint(v))
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(names, ", "))
+	}
+}
+
+// ValidateRange allows whole numbers from min to max.
+func ValidateRange(min, max int) Validator {
+	return func(value interface{}) error {
+		n := attributeNumber(value)
+		if n != float64(int(n)) || n < float64(min) || n > float64(max) {
+			return fmt.Errorf("must be a whole number from %d to %d", min, max)
+		}
+		return nil
+	}
+}
+
+// Validate checks the document d, which must consist of inserts only.
+// Errors give positions in UTF-16 code units, like the indices of Quill.
+func (s *Schema) Validate(d delta.Delta) error {
+	p := int64(0)
+	for _, op := range d.Ops {
+		if op.Insert == nil && op.InsertEmbed == nil {
+			return fmt.Errorf("Not a document: retain or delete at #%d", p)
+		}
+		err := s.check(op, p, UTF16)
+		if err != nil {
+			return err
+		}
+		if op.InsertEmbed != nil {
+			p++
+		} else {
+			p += unitLen(string(op.Insert), UTF16)
+		}
+	}
+	return nil
+}
+
+// check checks the insert op at position p, counted in unit. Runs of
+// newlines take line formats and the text between them inline formats, an
+// embed takes inline formats and those of EmbedFormats.
+func (s *Schema) check(op delta.Op, p int64, unit Unit) error {
+	if op.InsertEmbed != nil {
+		validate, ok := s.Embeds[op.InsertEmbed.Key]
+		if !ok {
+			return fmt.Errorf("Embed %s not allowed at #%d", op.InsertEmbed.Key, p)
+		}
+		if err := validate(op.InsertEmbed.Value); err != nil {
+			return fmt.Errorf("Embed %s at #%d: value %s", op.InsertEmbed.Key, p, err)
+		}
+		return s.checkFormats(op.Attributes, p, s.Inline, s.EmbedFormats)
+	}
+	text := string(op.Insert)
+	for text != "" {
+		i := strings.IndexFunc(text, func(r rune) bool {
+			return (r == '\n') != (text[0] == '\n')
+		})
+		if i < 0 {
+			i = len(text)
+		}
+		registry := s.Inline
+		if text[0] == '\n' {
+			registry = s.Block
+		}
+		err := s.checkFormats(op.Attributes, p, registry)
+		if err != nil {
+			return err
+		}
+		p += unitLen(text[:i], unit)
+		text = text[i:]
+	}
+	return nil
+}
+
+// checkFormats checks that each format in attrs is allowed by one of
+// registries, for the text at position p.
+func (s *Schema) checkFormats(attrs map[string]interface{}, p int64, registries ...map[string]Validator) error {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := attrs[name]
+		if value == nil {
+			continue
+		}
+		var validate Validator
+		for _, registry := range registries {
+			if v, ok := registry[name]; ok {
+				validate = v
+				break
+			}
+		}
+		if validate == nil {
+			return fmt.Errorf("Format %s not allowed at #%d", name, p)
+		}
+		if err := validate(value); err != nil {
+			return fmt.Errorf("Format %s=%v at #%d: value %s", name, value, p, err)
+		}
+	}
+	return nil
+}
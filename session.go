@@ -0,0 +1,280 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Session holds a set of named Files. Commands running in a session can
+// switch between them with "regexp" file addresses, and loop over them with
+// the X and Y commands. Each File still commits its own delta.
+type Session struct {
+	// Open creates the File for a name opened with the B command. data is
+	// what Context.FS holds for the name, or nil when it does not exist.
+	// Files are opened as a TextFile when Open is not set.
+	Open func(name string, data []byte) (File, error)
+
+	files   []*sessionFile
+	current *sessionFile
+	// runner is used when Context.Runner is nil, so cd holds from one Run
+	// to the next.
+	runner Runner
+}
+
+type sessionFile struct {
+	name     string
+	file     File
+	modified bool
+	mark     textRange // for Files that are not a MarkFile
+}
+
+func NewSession() *Session {
+	return &Session{
+		files: make([]*sessionFile, 0),
+	}
+}
+
+// Add puts file into the session under name, the first file added becomes
+// the current file.
+func (s *Session) Add(name string, file File) {
+	sf := &sessionFile{
+		name: name,
+		file: file,
+	}
+	s.files = append(s.files, sf)
+	if s.current == nil {
+		s.current = sf
+	}
+}
+
+// File returns the File with the given name, or nil if there is none.
+func (s *Session) File(name string) File {
+	for _, sf := range s.files {
+		if sf.name == name {
+			return sf.file
+		}
+	}
+	return nil
+}
+
+// Current returns the name and File commands start running in.
+func (s *Session) Current() (string, File) {
+	if s.current == nil {
+		return "", nil
+	}
+	return s.current.name, s.current.file
+}
+
+func (s *Session) Names() []string {
+	names := make([]string, 0, len(s.files))
+	for _, sf := range s.files {
+		names = append(names, sf.name)
+	}
+	return names
+}
+
+// innerSession tracks the files a single Run works on, for a plain Context
+// this is a session of just Context.File.
+type innerSession struct {
+	session *Session
+	lenient bool
+	schema  *Schema
+	files   []*innerFile
+	current *innerFile
+}
+
+func newInnerSession(context Context) (*innerSession, error) {
+	s := &innerSession{
+		session: context.Session,
+		lenient: context.Lenient,
+		schema:  context.Schema,
+		files:   make([]*innerFile, 0),
+	}
+	if s.session == nil {
+		f, err := newInnerFile(context.File)
+		if err != nil {
+			return nil, err
+		}
+		f.lenient = s.lenient
+		s.files = append(s.files, f)
+		s.current = f
+		return s, nil
+	}
+	for _, sf := range s.session.files {
+		f, err := newInnerFile(sf.file)
+		if err != nil {
+			return nil, err
+		}
+		f.name = sf.name
+		f.modified = sf.modified
+		if _, ok := sf.file.(MarkFile); !ok {
+			f.setUnitMark(sf.mark.q0, sf.mark.q1)
+		}
+		f.lenient = s.lenient
+		s.files = append(s.files, f)
+		if sf == s.session.current {
+			s.current = f
+		}
+	}
+	return s, nil
+}
+
+func (s *innerSession) lookup(name string) *innerFile {
+	for _, f := range s.files {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// open returns the file with the given name, reading it from fsys into a
+// new File when the session does not have it yet.
+func (s *innerSession) open(name string, fsys FS) (*innerFile, error) {
+	if f := s.lookup(name); f != nil {
+		return f, nil
+	}
+	if s.session == nil {
+		return nil, fmt.Errorf("Opening files needs a Session!")
+	}
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		data = nil
+	}
+	var file File
+	if s.session.Open != nil {
+		file, err = s.session.Open(name, data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		file = NewTextFile(string(data))
+	}
+	f, err := newInnerFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f.name = name
+	f.lenient = s.lenient
+	s.files = append(s.files, f)
+	return f, nil
+}
+
+// close drops f from the session together with its pending changes.
+func (s *innerSession) close(f *innerFile) error {
+	if s.session == nil {
+		return fmt.Errorf("Closing files needs a Session!")
+	}
+	for i, file := range s.files {
+		if file == f {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			break
+		}
+	}
+	if s.current == f {
+		s.current = nil
+	}
+	return nil
+}
+
+// rename gives f a new name, which lasts past the Run in the Session or
+// in f itself when it is a NamedFile.
+func (s *innerSession) rename(f *innerFile, name string) error {
+	if _, ok := f.file.(NamedFile); !ok && s.session == nil {
+		return fmt.Errorf("Naming files needs a Session or a NamedFile!")
+	}
+	f.name = name
+	return nil
+}
+
+func (s *innerSession) match(reStr string) ([]*innerFile, error) {
+	re, err := compileRegexp(reStr)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*innerFile, 0)
+	for _, f := range s.files {
+		if re.MatchString(f.name) {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+func (s *innerSession) matchFile(reStr string) (*innerFile, error) {
+	files, err := s.match(reStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No file matches \"%s\"", reStr)
+	}
+	if len(files) > 1 {
+		return nil, fmt.Errorf("Too many files match \"%s\"", reStr)
+	}
+	return files[0], nil
+}
+
+func (s *innerSession) menuLine(f *innerFile) string {
+	modified := byte(' ')
+	if f.modified || f.pending() {
+		modified = '\''
+	}
+	current := byte(' ')
+	if f == s.current {
+		current = '.'
+	}
+	return fmt.Sprintf("%c-%c %s\n", modified, current, f.name)
+}
+
+// commit applies the pending changes of every file, and carries the file
+// list back to the Session when there is one. Nothing is applied unless the
+// changes of all files satisfy the schema.
+func (s *innerSession) commit() error {
+	for _, f := range s.files {
+		err := f.Validate(s.schema)
+		if err != nil {
+			if s.session != nil {
+				return fmt.Errorf("%s: %v", f.name, err)
+			}
+			return err
+		}
+	}
+	for _, f := range s.files {
+		if f.clean {
+			f.modified = false
+			f.clean = false
+		} else if f.pending() {
+			f.modified = true
+		}
+		err := f.Commit()
+		if err != nil {
+			return err
+		}
+		if n, ok := f.file.(NamedFile); ok && n.Name() != f.name {
+			n.SetName(f.name)
+		}
+	}
+	if s.session == nil {
+		return nil
+	}
+	s.session.files = make([]*sessionFile, 0, len(s.files))
+	s.session.current = nil
+	for _, f := range s.files {
+		sf := &sessionFile{
+			name:     f.name,
+			file:     f.file,
+			modified: f.modified,
+		}
+		sf.mark.q0, sf.mark.q1 = f.unitMark()
+		s.session.files = append(s.session.files, sf)
+		if f == s.current {
+			s.session.current = sf
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package editor
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+func newTestSession() (*Session, map[string]*DeltaFile) {
+	files := map[string]*DeltaFile{
+		"a.md":  NewDeltaFile(*delta.New(nil).Insert("foo in a\nsecond line\n", nil)),
+		"b.md":  NewDeltaFile(*delta.New(nil).Insert("foo in b\n", nil)),
+		"c.txt": NewDeltaFile(*delta.New(nil).Insert("foo in c\n", nil)),
+	}
+	s := NewSession()
+	s.Add("a.md", files["a.md"])
+	s.Add("b.md", files["b.md"])
+	s.Add("c.txt", files["c.txt"])
+	return s, files
+}
+
+func runSession(t *testing.T, s *Session, command string) string {
+	cmd, err := Compile(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = cmd.Run(Context{
+		Session: s,
+		Printer: buf,
+	})
+	if err != nil {
+		t.Fatalf("Error running command %s: %v", command, err)
+	}
+	return buf.String()
+}
+
+func TestSessionLoops(t *testing.T) {
+	s, files := newTestSession()
+	runSession(t, s, "X/\\.md$/ ,s/foo/bar/g")
+	expected := map[string]string{
+		"a.md":  "bar in a\nsecond line\n",
+		"b.md":  "bar in b\n",
+		"c.txt": "foo in c\n",
+	}
+	for name, content := range expected {
+		if actual := string(files[name].Bytes()); actual != content {
+			t.Fatalf("Invalid content of %s, expected: \"%s\", actual: \"%s\"", name, content, actual)
+		}
+	}
+	printed := runSession(t, s, "X")
+	if printed != "'-. a.md\n'-. b.md\n -. c.txt\n" {
+		t.Fatalf("Invalid menu: \"%s\"", printed)
+	}
+	printed = runSession(t, s, "Y/\\.md$/ ,p")
+	if printed != "foo in c\n" {
+		t.Fatalf("Invalid print data: \"%s\"", printed)
+	}
+}
+
+func TestFileAddress(t *testing.T) {
+	s, files := newTestSession()
+	printed := runSession(t, s, "\"a\\.md\"2p")
+	if printed != "second line\n" {
+		t.Fatalf("Invalid print data: \"%s\"", printed)
+	}
+	if name, _ := s.Current(); name != "a.md" {
+		t.Fatalf("Invalid current file: %s", name)
+	}
+	runSession(t, s, "\"b\\.md\"1t\"c\\.txt\"0")
+	if actual := string(files["c.txt"].Bytes()); actual != "foo in b\nfoo in c\n" {
+		t.Fatalf("Invalid content of c.txt: \"%s\"", actual)
+	}
+	printed = runSession(t, s, "\"b\\.md\"1,2p")
+	if printed != "foo in b\n" {
+		t.Fatalf("Invalid print data for a compound address: \"%s\"", printed)
+	}
+	printed = runSession(t, s, "\"b\\.md\",p")
+	if printed != "foo in b\n" {
+		t.Fatalf("Invalid print data for a compound address: \"%s\"", printed)
+	}
+	cmd, err := Compile("\"\\.md\"p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Run(Context{Session: s}); err == nil {
+		t.Fatalf("Expected an error for an ambiguous file address")
+	}
+}
+
+type testFS struct {
+	fstest.MapFS
+}
+
+func (f testFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f.MapFS[name] = &fstest.MapFile{
+		Data: data,
+		Mode: perm,
+	}
+	return nil
+}
+
+type namedFile struct {
+	*TextFile
+	name string
+}
+
+func (f *namedFile) Name() string {
+	return f.name
+}
+
+func (f *namedFile) SetName(name string) {
+	f.name = name
+}
+
+func TestNamedFile(t *testing.T) {
+	fsys := testFS{fstest.MapFS{
+		"in.txt": &fstest.MapFile{Data: []byte("from disk\n")},
+	}}
+	f := &namedFile{TextFile: NewTextFile("")}
+	for _, command := range []string{"e in.txt", "s/from/to/", "w"} {
+		cmd, err := Compile(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmd.Run(Context{File: f, FS: fsys})
+		if err != nil {
+			t.Fatalf("Error running command %s: %v", command, err)
+		}
+	}
+	if f.name != "in.txt" || string(fsys.MapFS["in.txt"].Data) != "to disk\n" {
+		t.Fatalf("Invalid name %s or written data \"%s\"", f.name, fsys.MapFS["in.txt"].Data)
+	}
+	if err := run("f foo.txt", NewTextFile("")); err == nil {
+		t.Fatal("Expected naming a File without a Session to fail")
+	}
+}
+
+func TestWriteCountsUnits(t *testing.T) {
+	fsys := testFS{fstest.MapFS{}}
+	var printed bytes.Buffer
+	cmd, err := Compile(",w out.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Run(Context{
+		File:    NewDeltaFile(*delta.New(nil).Insert("日本語 abc 😀 déf\n", nil)),
+		FS:      fsys,
+		Printer: &printed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed.String() != "out.txt: #15\n" {
+		t.Fatalf("Invalid print: \"%s\"", printed.String())
+	}
+}
+
+func TestFileCommands(t *testing.T) {
+	s, files := newTestSession()
+	fsys := testFS{fstest.MapFS{
+		"greeting.txt": &fstest.MapFile{Data: []byte("hello")},
+		"b.md":         &fstest.MapFile{Data: []byte("b from disk\n")},
+	}}
+	for _, command := range []string{"1,/foo/r greeting.txt", ",w out.txt", "X/b\\.md/ e", "\"c\\.txt\"1w"} {
+		cmd, err := Compile(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cmd.Run(Context{
+			Session: s,
+			FS:      fsys,
+		})
+		if err != nil {
+			t.Fatalf("Error running command %s: %v", command, err)
+		}
+	}
+	if actual := string(files["a.md"].Bytes()); actual != "hello in a\nsecond line\n" {
+		t.Fatalf("Invalid content of a.md: \"%s\"", actual)
+	}
+	if actual := string(files["b.md"].Bytes()); actual != "b from disk\n" {
+		t.Fatalf("Invalid content of b.md: \"%s\"", actual)
+	}
+	expectedFiles := map[string]string{
+		"out.txt": "hello in a\nsecond line\n",
+		"c.txt":   "foo in c\n",
+	}
+	for name, content := range expectedFiles {
+		if actual := string(fsys.MapFS[name].Data); actual != content {
+			t.Fatalf("Invalid written file %s: \"%s\"", name, actual)
+		}
+	}
+}
+
+func TestBufferCommands(t *testing.T) {
+	s, _ := newTestSession()
+	fsys := testFS{fstest.MapFS{
+		"new.txt": &fstest.MapFile{Data: []byte("new file\n")},
+	}}
+	runs := []struct {
+		command string
+		print   string
+	}{
+		{"B new.txt", " -. new.txt\n"},
+		{",s/new/old/", ""},
+		{"f old.txt", "'-. old.txt\n"},
+		{"D b.md", ""},
+		{"b a.md", " -. a.md\n"},
+		{"n", " -. a.md\n -  c.txt\n'-  old.txt\n"},
+		{"D", ""},
+		{"n", " -  c.txt\n'-  old.txt\n"},
+	}
+	for _, run := range runs {
+		cmd, err := Compile(run.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := bytes.NewBuffer(nil)
+		err = cmd.Run(Context{
+			Session: s,
+			Printer: buf,
+			FS:      fsys,
+		})
+		if err != nil {
+			t.Fatalf("Error running command %s: %v", run.command, err)
+		}
+		if buf.String() != run.print {
+			t.Fatalf("Invalid print data for command %s: \"%s\"", run.command, buf.String())
+		}
+	}
+	if s.File("old.txt") == nil || s.File("b.md") != nil {
+		t.Fatalf("Invalid files in session: %v", s.Names())
+	}
+	cmd, err := Compile("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Run(Context{Session: s}); err == nil {
+		t.Fatalf("Expected an error without a current file")
+	}
+}
+
+func TestSessionRunner(t *testing.T) {
+	s, _ := newTestSession()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSession(t, s, "cd "+dir)
+	print := runSession(t, s, "!pwd")
+	if print != dir+"\n!\n" {
+		t.Fatalf("Directory of cd not kept by the session: \"%s\"", print)
+	}
+	if err := run("cd "+dir, NewTextFile("")); err == nil {
+		t.Fatal("Expected cd to fail without a Session or Runner")
+	}
+}
+
+func TestBlockSwitchesFile(t *testing.T) {
+	s, files := newTestSession()
+	print := runSession(t, s, "{\nb b.md\n,p\n}")
+	if print != " -. b.md\nfoo in b\n" {
+		t.Fatalf("Invalid print data after b in a block: \"%s\"", print)
+	}
+	cmd, err := Compile("{\nD\n,d\n}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.Run(Context{Session: s}); err == nil {
+		t.Fatal("Expected an error after D of the current file in a block")
+	}
+	if text := debugDeltaString(t, files["b.md"].Delta); text != debugDeltaString(t, *delta.New(nil).Insert("foo in b\n", nil)) {
+		t.Fatalf("Dropped file changed: %s", text)
+	}
+	print = runSession(t, s, "{\nD\nb a.md\n,p\n}")
+	if print != " -. a.md\nfoo in a\nsecond line\n" {
+		t.Fatalf("Invalid print data after D in a block: \"%s\"", print)
+	}
+	if s.File("b.md") != nil {
+		t.Fatalf("Invalid files in session: %v", s.Names())
+	}
+}
+
+func TestSessionMark(t *testing.T) {
+	s, _ := newTestSession()
+	runSession(t, s, "/second/k")
+	runSession(t, s, "0i/new /")
+	if print := runSession(t, s, "'p"); print != "second" {
+		t.Fatalf("Mark not kept by the session: \"%s\"", print)
+	}
+}
+
+func TestFileAddressWithoutCurrent(t *testing.T) {
+	s, _ := newTestSession()
+	runSession(t, s, "D")
+	if print := runSession(t, s, "\"c\"p"); print != "" {
+		t.Fatalf("Invalid print data: \"%s\"", print)
+	}
+	s, _ = newTestSession()
+	runSession(t, s, "D")
+	if print := runSession(t, s, "\"c\",p"); print != "foo in c\n" {
+		t.Fatalf("Invalid print data: \"%s\"", print)
+	}
+}
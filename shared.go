@@ -0,0 +1,194 @@
+package editor
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// maxSharedHistory is how many changes a SharedDeltaFile keeps for moving
+// the dot of older snapshots along.
+const maxSharedHistory = 1024
+
+// SharedDeltaFile is a Quill document that can be used from several
+// goroutines at once, such as a UI reading it while scripts change it.
+// Commands do not run on it directly but on a DeltaSnapshot, which reads
+// the document as it was when the snapshot was taken together with the
+// changes composed into the snapshot itself. A snapshot only composes into
+// the document when nothing else did since it was taken, so changes apply
+// one after the other as if made in turn, and Delta never returns a
+// document with a change applied only in part.
+type SharedDeltaFile struct {
+	// run is held through a whole Run so commands do not work on a
+	// document another one is about to change.
+	run     sync.Mutex
+	mu      sync.RWMutex
+	doc     delta.Delta // counts runes like the delta package
+	unit    Unit
+	version int
+	// history holds the last changes, the last one took the document to
+	// version.
+	history    []delta.Delta
+	start, end int64
+}
+
+func NewSharedDeltaFile(d delta.Delta) *SharedDeltaFile {
+	return NewSharedDeltaFileUnit(d, UTF16)
+}
+
+// NewSharedDeltaFileUnit returns a SharedDeltaFile whose snapshots count
+// positions in unit.
+func NewSharedDeltaFileUnit(d delta.Delta, unit Unit) *SharedDeltaFile {
+	return &SharedDeltaFile{
+		doc:  d,
+		unit: unit,
+	}
+}
+
+// Delta returns the current document.
+func (s *SharedDeltaFile) Delta() delta.Delta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.doc
+}
+
+// Version returns how many changes have been applied to the document.
+func (s *SharedDeltaFile) Version() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// Snapshot returns a File reading the current document, with the dot left
+// by the last Run. A snapshot must only be used by one goroutine at a time.
+func (s *SharedDeltaFile) Snapshot() *DeltaSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f := &DeltaSnapshot{
+		shared:  s,
+		file:    NewDeltaFileUnit(s.doc, s.unit),
+		version: s.version,
+	}
+	f.file.Select(s.start, s.end)
+	return f
+}
+
+// Run runs cmd on a snapshot of the document, context.File is replaced by
+// the snapshot. Runs happen one at a time, so they always see the changes
+// of the ones before. The dot the command leaves is kept for the next
+// snapshot, moved along with the changes others made in the meantime.
+func (s *SharedDeltaFile) Run(cmd Cmd, context Context) error {
+	s.run.Lock()
+	defer s.run.Unlock()
+	f := s.Snapshot()
+	context.File = f
+	context.Session = nil
+	err := cmd.Run(context)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	behind, err := f.behind()
+	if err != nil {
+		return err
+	}
+	toRunes, _ := f.file.runeMaps()
+	_, fromRunes := NewDeltaFileUnit(s.doc, s.unit).runeMaps()
+	position := func(p int64) int64 {
+		return fromRunes(int64(behind.TransformPosition(int(toRunes(p)), false)))
+	}
+	q0, q1 := f.Dot()
+	s.start, s.end = position(q0), position(q1)
+	return nil
+}
+
+// DeltaSnapshot is a File reading a SharedDeltaFile as it was when the
+// snapshot was taken, with its own changes applied. Compose applies them
+// to the shared document as well.
+type DeltaSnapshot struct {
+	shared *SharedDeltaFile
+	file   *DeltaFile
+	// version is the version of the shared document the snapshot was taken
+	// at or last composed into.
+	version int
+}
+
+// behind returns the change taking the snapshot to the current shared
+// document, whose lock must be held.
+func (f *DeltaSnapshot) behind() (delta.Delta, error) {
+	s := f.shared
+	n := s.version - f.version
+	if n > len(s.history) {
+		return delta.Delta{}, fmt.Errorf("Snapshot is %d changes behind, too old to compose!", n)
+	}
+	d := *delta.New(nil)
+	for _, change := range s.history[len(s.history)-n:] {
+		d = *d.Compose(change)
+	}
+	return d, nil
+}
+
+// Version returns the version of the shared document the snapshot was
+// taken at, or last composed into.
+func (f *DeltaSnapshot) Version() int {
+	return f.version
+}
+
+func (f *DeltaSnapshot) Unit() Unit {
+	return f.file.Unit()
+}
+
+// Delta returns the document the snapshot reads, with its own changes but
+// not those of others.
+func (f *DeltaSnapshot) Delta() delta.Delta {
+	return f.file.Delta
+}
+
+func (f *DeltaSnapshot) Select(start, end int64) {
+	f.file.Select(start, end)
+}
+
+func (f *DeltaSnapshot) Dot() (start, end int64) {
+	return f.file.Dot()
+}
+
+func (f *DeltaSnapshot) Len() (int64, error) {
+	return f.file.Len()
+}
+
+func (f *DeltaSnapshot) Reader(start, end int64) io.ReadSeeker {
+	return f.file.Reader(start, end)
+}
+
+func (f *DeltaSnapshot) Contents(start, end int64) delta.Delta {
+	return f.file.Contents(start, end)
+}
+
+// Compose applies d to the snapshot and to the shared document. It fails
+// when others composed changes since the snapshot was taken, since d was
+// made without seeing them; take a new snapshot and make it again.
+func (f *DeltaSnapshot) Compose(d delta.Delta) error {
+	if len(d.Ops) == 0 {
+		return nil
+	}
+	toRunes, _ := f.file.runeMaps()
+	change := convertDelta(d, toRunes)
+	s := f.shared
+	s.mu.Lock()
+	if n := s.version - f.version; n > 0 {
+		s.mu.Unlock()
+		return fmt.Errorf("Snapshot is %d changes behind the document, take a new one!", n)
+	}
+	s.doc = *s.doc.Compose(change)
+	s.history = append(s.history, change)
+	if len(s.history) > maxSharedHistory {
+		s.history = append([]delta.Delta(nil), s.history[len(s.history)-maxSharedHistory:]...)
+	}
+	s.version++
+	f.version = s.version
+	s.mu.Unlock()
+	return f.file.Compose(d)
+}
@@ -0,0 +1,156 @@
+package editor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+func TestSharedDeltaFileSnapshot(t *testing.T) {
+	s := NewSharedDeltaFile(*delta.New(nil).Insert("hello wörld\n", nil))
+	old := s.Snapshot()
+	cmd, err := Compile("/hello/c/HELLO there,/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Run(cmd, Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := printableText(old.Delta()); text != "hello wörld\n" {
+		t.Fatalf("Snapshot changed along with the document: %q", text)
+	}
+	if err := run("/wörld/c/world/", old); err == nil {
+		t.Fatal("Expected composing into a snapshot behind the document to fail")
+	}
+	if text := printableText(s.Delta()); text != "HELLO there, wörld\n" {
+		t.Fatalf("Document changed by a snapshot behind it: %q", text)
+	}
+	f := s.Snapshot()
+	err = run("/wörld/c/world/", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "HELLO there, world\n"
+	if text := printableText(s.Delta()); text != expected {
+		t.Fatalf("Invalid content, expected: %q, actual: %q", expected, text)
+	}
+	if text := printableText(f.Delta()); text != expected {
+		t.Fatalf("Invalid snapshot content after Compose: %q", text)
+	}
+	if s.Version() != 2 || f.Version() != 2 || old.Version() != 0 {
+		t.Fatalf("Invalid versions: %d, %d, %d", s.Version(), f.Version(), old.Version())
+	}
+	q0, q1 := f.Dot()
+	if q0 != 13 || q1 != 18 {
+		t.Fatalf("Invalid dot! Expected: (13, 18), actual: (%d, %d)", q0, q1)
+	}
+	cmd, err = Compile("/there/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Run(cmd, Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q0, q1 = s.Snapshot().Dot()
+	if q0 != 6 || q1 != 11 {
+		t.Fatalf("Invalid dot of a new snapshot! Expected: (6, 11), actual: (%d, %d)", q0, q1)
+	}
+}
+
+// slowWriter discards what is written to it after a while.
+type slowWriter struct{}
+
+func (slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return len(p), nil
+}
+
+func TestSharedDeltaFileConflicting(t *testing.T) {
+	s := NewSharedDeltaFile(*delta.New(nil).Insert("color: red\n", nil))
+	// Printing slowly first leaves time for the other runs to start.
+	cmd, err := Compile("{\n,p\n,s/red/blue/\n}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const runs = 8
+	errs := make(chan error)
+	for i := 0; i < runs; i++ {
+		go func() {
+			errs <- s.Run(cmd, Context{Printer: slowWriter{}})
+		}()
+	}
+	for i := 0; i < runs; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if text := printableText(s.Delta()); text != "color: blue\n" {
+		t.Fatalf("Invalid content after conflicting substitutions: %q", text)
+	}
+	if s.Version() != 1 {
+		t.Fatalf("Expected version 1, actual: %d", s.Version())
+	}
+}
+
+func TestSharedDeltaFileConcurrent(t *testing.T) {
+	s := NewSharedDeltaFile(*delta.New(nil).Insert("start\n", nil))
+	const writers, lines = 4, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				errs <- nil
+				return
+			default:
+			}
+			text := printableText(s.Delta())
+			for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+				if line != "start" && !strings.HasPrefix(line, "line ") {
+					errs <- fmt.Errorf("Half composed document: %q", text)
+					return
+				}
+			}
+		}
+	}()
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				cmd, err := Compile(fmt.Sprintf("$a/line %d %d\\n/", w, i))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if err := s.Run(cmd, Context{}); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	for i := 0; i < writers+1; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	text := printableText(s.Delta())
+	if n := strings.Count(text, "\n"); n != writers*lines+1 {
+		t.Fatalf("Expected %d lines, found %d", writers*lines+1, n)
+	}
+	if s.Version() != writers*lines {
+		t.Fatalf("Expected version %d, actual: %d", writers*lines, s.Version())
+	}
+}
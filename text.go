@@ -0,0 +1,121 @@
+package editor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// TextFile is a File holding plain text in memory, for using the commands
+// on text that is not a Quill document. Its positions count runes, so the
+// deltas it composes and returns from Changes work with the delta package
+// as they are.
+type TextFile struct {
+	text       []rune
+	changes    delta.Delta
+	start, end int64
+}
+
+func NewTextFile(s string) *TextFile {
+	return &TextFile{
+		text: []rune(s),
+	}
+}
+
+func (f *TextFile) Unit() Unit {
+	return Rune
+}
+
+func (f *TextFile) String() string {
+	return string(f.text)
+}
+
+// Changes returns all deltas composed so far as a single one.
+func (f *TextFile) Changes() delta.Delta {
+	return f.changes
+}
+
+func (f *TextFile) Select(start, end int64) {
+	f.start = start
+	f.end = end
+}
+
+func (f *TextFile) Dot() (start, end int64) {
+	start = f.start
+	end = f.end
+	return
+}
+
+func (f *TextFile) Len() (int64, error) {
+	return int64(len(f.text)), nil
+}
+
+func (f *TextFile) Reader(start, end int64) io.ReadSeeker {
+	l := int64(len(f.text))
+	if end < start || start > l {
+		return nil
+	}
+	if end > l {
+		end = l
+	}
+	return strings.NewReader(string(f.text[start:end]))
+}
+
+// Compose applies d, which must only hold plain text.
+func (f *TextFile) Compose(d delta.Delta) error {
+	if len(d.Ops) == 0 {
+		return nil
+	}
+	err := checkPlainText(d, "TextFile")
+	if err != nil {
+		return err
+	}
+	inserted := 0
+	for _, op := range d.Ops {
+		inserted += len(op.Insert)
+	}
+	text := make([]rune, 0, len(f.text)+inserted)
+	p := 0
+	for _, op := range d.Ops {
+		switch {
+		case op.Retain != nil:
+			n := *op.Retain
+			if p+n > len(f.text) {
+				return fmt.Errorf("Retain past the end of file at #%d!", p)
+			}
+			text = append(text, f.text[p:p+n]...)
+			p += n
+		case op.Delete != nil:
+			n := *op.Delete
+			if p+n > len(f.text) {
+				return fmt.Errorf("Delete past the end of file at #%d!", p)
+			}
+			p += n
+		default:
+			text = append(text, op.Insert...)
+		}
+	}
+	f.text = append(text, f.text[p:]...)
+	f.changes = *f.changes.Compose(d)
+	q0, q1 := changedRange(d)
+	f.Select(q0, q1)
+	return nil
+}
+
+// checkPlainText returns an error when d holds embeds or attributes, which
+// the File called name can't keep. Removing attributes is fine.
+func checkPlainText(d delta.Delta, name string) error {
+	for _, op := range d.Ops {
+		if op.InsertEmbed != nil {
+			return fmt.Errorf("%s can't hold embeds!", name)
+		}
+		for _, value := range op.Attributes {
+			if value != nil {
+				return fmt.Errorf("%s can't hold attributes!", name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package editor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+func TestTextFile(t *testing.T) {
+	e := NewTextFile("listen = 8080\nhost = héllo\n# port = 1\n")
+	for _, command := range []string{
+		",x/^[a-z]+ = .*\\n/ s/ = (.*)/: \\1/",
+		"/héllo/c/wörld/",
+		"$-d",
+	} {
+		err := run(command, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "listen: 8080\nhost: wörld\n"
+	if e.String() != expected {
+		t.Fatalf("Invalid content, expected: %q, actual: %q", expected, e.String())
+	}
+	expectedChange := *delta.New(nil).Retain(6, nil).Insert(": 8080", nil).Delete(7).
+		Retain(5, nil).Insert(": wörld", nil).Delete(8).Retain(1, nil).Delete(11)
+	if !reflect.DeepEqual(e.Changes(), expectedChange) {
+		t.Fatalf("Invalid change, expected: %s, actual: %s",
+			debugDeltaString(t, expectedChange), debugDeltaString(t, e.Changes()))
+	}
+	if err := run("1fmt bold", e); err == nil {
+		t.Fatal("Expected formatting a TextFile to fail")
+	}
+}
@@ -0,0 +1,238 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// Tree is a set of files below a directory to run a command on, as a
+// codemod does. Patterns are matched with path.Match against the slash
+// separated name relative to Root, a pattern without a slash against the
+// base name of each file and directory as well.
+type Tree struct {
+	Root string
+	// Include selects the files to run on, all files when it is empty.
+	Include []string
+	// Exclude drops files from Include, and directories with all they
+	// hold.
+	Exclude []string
+	// VCS includes the directories of version control systems, such as
+	// .git, which are left out otherwise.
+	VCS bool
+}
+
+// vcsDirs are the directories version control systems keep their data in.
+var vcsDirs = []string{".bzr", ".git", ".hg", ".svn", "CVS", "_darcs"}
+
+// TreeRun holds the outcome of running a command on a Tree. Nothing is
+// written until Commit.
+type TreeRun struct {
+	Root      string
+	Files     []*TreeFile
+	committed bool
+}
+
+// TreeFile is the outcome of running a command on a single file.
+type TreeFile struct {
+	// Name is relative to the root of the Tree, with slashes.
+	Name string
+	// Changes is what the command changed, counting runes, empty when the
+	// file stays the same.
+	Changes delta.Delta
+	// Output is what the command printed.
+	Output []byte
+	Err    error
+	// Skipped is set for files that are not UTF-8 text, such as binaries
+	// or Latin-1 text, the command does not run on them so they are never
+	// written back.
+	Skipped bool
+	text    *TextFile
+	perm    os.FileMode
+}
+
+// Modified reports if the command changed the file.
+func (f *TreeFile) Modified() bool {
+	return f.Err == nil && len(f.Changes.Ops) > 0
+}
+
+// Files returns the names of the files in the tree, sorted.
+func (t *Tree) Files() ([]string, error) {
+	for _, pattern := range append(append([]string{}, t.Include...), t.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Bad pattern %s", pattern)
+		}
+	}
+	names := make([]string, 0)
+	err := filepath.WalkDir(t.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(t.Root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if d.IsDir() && !t.VCS && matchPatterns(vcsDirs, d.Name()) {
+			return filepath.SkipDir
+		}
+		if matchPatterns(t.Exclude, name) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && (len(t.Include) == 0 || matchPatterns(t.Include, name)) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func matchPatterns(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Run runs cmd on each file of the tree, in memory as a TextFile, skipping
+// the files that are not UTF-8 text. context is used for every file, apart
+// from its File, Session and Printer. Errors of the command are kept in the
+// TreeFile, the error returned is for listing the tree.
+func (t *Tree) Run(cmd Cmd, context Context) (*TreeRun, error) {
+	names, err := t.Files()
+	if err != nil {
+		return nil, err
+	}
+	run := &TreeRun{
+		Root:  t.Root,
+		Files: make([]*TreeFile, 0, len(names)),
+	}
+	for _, name := range names {
+		f := &TreeFile{
+			Name: name,
+		}
+		run.Files = append(run.Files, f)
+		p := filepath.Join(t.Root, filepath.FromSlash(name))
+		stat, err := os.Stat(p)
+		if err != nil {
+			f.Err = err
+			continue
+		}
+		f.perm = stat.Mode().Perm()
+		data, err := os.ReadFile(p)
+		if err != nil {
+			f.Err = err
+			continue
+		}
+		if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+			f.Skipped = true
+			continue
+		}
+		f.text = NewTextFile(string(data))
+		var output bytes.Buffer
+		c := context
+		c.File = f.text
+		c.Session = nil
+		c.Printer = &output
+		f.Err = cmd.Run(c)
+		f.Output = output.Bytes()
+		f.Changes = f.text.Changes()
+	}
+	return run, nil
+}
+
+// Err returns the errors of the files the command failed on, or nil when
+// it ran on all of them.
+func (r *TreeRun) Err() error {
+	failed := make([]string, 0)
+	for _, f := range r.Files {
+		if f.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", f.Name, f.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(failed, "\n"))
+}
+
+// Modified returns the files the command changed.
+func (r *TreeRun) Modified() []*TreeFile {
+	files := make([]*TreeFile, 0)
+	for _, f := range r.Files {
+		if f.Modified() {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Commit writes all modified files, or none when the command failed on any
+// file. The new contents are written and synced next to each file first,
+// then moved over the files, so a failure while writing leaves every file
+// as it was.
+func (r *TreeRun) Commit() error {
+	if r.committed {
+		return fmt.Errorf("Tree is committed already!")
+	}
+	if err := r.Err(); err != nil {
+		return fmt.Errorf("Nothing committed, the command failed on some files:\n%v", err)
+	}
+	files := r.Modified()
+	temps := make([]string, 0, len(files))
+	for _, f := range files {
+		tmp, err := writeTemp(r.path(f), f.perm, func(w io.Writer) error {
+			_, err := io.WriteString(w, f.text.String())
+			return err
+		})
+		if err != nil {
+			for _, tmp := range temps {
+				os.Remove(tmp)
+			}
+			return fmt.Errorf("Nothing committed, writing %s failed: %v", f.Name, err)
+		}
+		temps = append(temps, tmp)
+	}
+	r.committed = true
+	for i, f := range files {
+		err := os.Rename(temps[i], r.path(f))
+		if err != nil {
+			for _, tmp := range temps[i:] {
+				os.Remove(tmp)
+			}
+			return fmt.Errorf("Committing %s failed after %d of %d files: %v", f.Name, i, len(files), err)
+		}
+		syncDir(filepath.Dir(r.path(f)))
+	}
+	return nil
+}
+
+func (r *TreeRun) path(f *TreeFile) string {
+	return filepath.Join(r.Root, filepath.FromSlash(f.Name))
+}
@@ -0,0 +1,191 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(p, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readTree(t *testing.T, dir string, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTree(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.go":         "foo()\n",
+		"b.go":         "bar()\n",
+		"sub/c.go":     "foo(foo())\n",
+		"vendor/d.go":  "foo()\n",
+		"notes.txt":    "foo\n",
+		"sub/test.txt": "foo\n",
+	})
+	tree := &Tree{
+		Root:    dir,
+		Include: []string{"*.go", "sub/*.txt"},
+		Exclude: []string{"vendor"},
+	}
+	files, err := tree.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{"a.go", "b.go", "sub/c.go", "sub/test.txt"}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("Invalid files, expected: %v, actual: %v", expectedFiles, files)
+	}
+	cmd, err := Compile(",x/foo/ {\n=\nc/baz/\n}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := tree.Run(cmd, Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run.Err(); err != nil {
+		t.Fatal(err)
+	}
+	modified := make([]string, 0)
+	for _, f := range run.Modified() {
+		modified = append(modified, f.Name)
+	}
+	expectedModified := []string{"a.go", "sub/c.go", "sub/test.txt"}
+	if !reflect.DeepEqual(modified, expectedModified) {
+		t.Fatalf("Invalid modified files, expected: %v, actual: %v", expectedModified, modified)
+	}
+	if string(run.Files[2].Output) != "1\n1\n" {
+		t.Fatalf("Invalid output: %q", run.Files[2].Output)
+	}
+	if readTree(t, dir, "a.go") != "foo()\n" {
+		t.Fatal("Expected no change before Commit")
+	}
+	err = run.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"a.go":        "baz()\n",
+		"b.go":        "bar()\n",
+		"sub/c.go":    "baz(baz())\n",
+		"vendor/d.go": "foo()\n",
+		"notes.txt":   "foo\n",
+	} {
+		if content := readTree(t, dir, name); content != expected {
+			t.Fatalf("Invalid content of %s, expected: %q, actual: %q", name, expected, content)
+		}
+	}
+	stat, err := os.Stat(filepath.Join(dir, "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm() != 0600 {
+		t.Fatalf("Invalid permissions: %v", stat.Mode().Perm())
+	}
+	if err := run.Commit(); err == nil {
+		t.Fatal("Expected committing twice to fail")
+	}
+}
+
+func TestTreeAllOrNothing(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.txt": "needle\n",
+		"b.txt": "hay\n",
+	})
+	cmd, err := Compile("/needle/c/pin/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := (&Tree{Root: dir}).Run(cmd, Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Err() == nil || run.Files[1].Err == nil {
+		t.Fatal("Expected the command to fail on b.txt")
+	}
+	if err := run.Commit(); err == nil {
+		t.Fatal("Expected Commit to fail")
+	}
+	if content := readTree(t, dir, "a.txt"); content != "needle\n" {
+		t.Fatalf("Expected a.txt to stay the same, actual: %q", content)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected no files left behind, found %d entries", len(entries))
+	}
+}
+
+func TestTreeSkips(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.txt":          "caf\xe9 foo\n",
+		"b.txt":          "foo\x00\n",
+		"c.txt":          "foo\n",
+		".git/config":    "foo\n",
+		"sub/.hg/hgrc":   "foo\n",
+		"sub/d.txt":      "foo\n",
+		"sub/.gitignore": "foo\n",
+	})
+	tree := &Tree{Root: dir}
+	files, err := tree.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{"a.txt", "b.txt", "c.txt", "sub/.gitignore", "sub/d.txt"}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("Invalid files, expected: %v, actual: %v", expectedFiles, files)
+	}
+	cmd, err := Compile(",x/foo/c/bar/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := tree.Run(cmd, Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.Files[0].Skipped || !run.Files[1].Skipped || run.Files[2].Skipped {
+		t.Fatal("Expected only the files that are not UTF-8 text to be skipped")
+	}
+	err = run.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"a.txt":       "caf\xe9 foo\n",
+		"b.txt":       "foo\x00\n",
+		"c.txt":       "bar\n",
+		".git/config": "foo\n",
+	} {
+		if content := readTree(t, dir, name); content != expected {
+			t.Fatalf("Invalid content of %s, expected: %q, actual: %q", name, expected, content)
+		}
+	}
+	tree.VCS = true
+	files, err = tree.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 7 {
+		t.Fatalf("Expected the VCS directories to be included: %v", files)
+	}
+}
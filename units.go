@@ -0,0 +1,260 @@
+package editor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/fmpwizard/go-quilljs-delta/delta"
+)
+
+// Unit is what the positions of a File count, in Select, Dot, Mark, Len,
+// Reader and the deltas it composes. Commands use it for #n addresses and
+// the output of = too.
+type Unit int
+
+const (
+	// UTF16 counts UTF-16 code units like the indices of Quill, so a
+	// character outside the Basic Multilingual Plane takes two.
+	UTF16 Unit = iota
+	Rune
+	Byte
+)
+
+func (u Unit) String() string {
+	switch u {
+	case UTF16:
+		return "utf-16"
+	case Rune:
+		return "rune"
+	case Byte:
+		return "byte"
+	}
+	return fmt.Sprintf("unit %d", int(u))
+}
+
+// UnitFile is implemented by Files whose positions are not bytes, Files not
+// implementing it count bytes.
+type UnitFile interface {
+	File
+	Unit() Unit
+}
+
+func fileUnit(file File) Unit {
+	if f, ok := file.(UnitFile); ok {
+		return f.Unit()
+	}
+	return Byte
+}
+
+// runeLen returns how many units r, taking size bytes, counts as.
+func runeLen(r rune, size int, unit Unit) int64 {
+	switch unit {
+	case UTF16:
+		if r >= 0x10000 && r <= utf8.MaxRune {
+			return 2
+		}
+		return 1
+	case Rune:
+		return 1
+	}
+	return int64(size)
+}
+
+// unitLen returns the length of s in unit.
+func unitLen(s string, unit Unit) int64 {
+	if unit == Byte {
+		return int64(len(s))
+	}
+	l := int64(0)
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		l += runeLen(r, size, unit)
+		i += size
+	}
+	return l
+}
+
+// unitIndex maps between byte offsets of a text and positions counted in
+// another unit. Only characters whose length differs between the two are
+// kept, as runs of characters of the same length, so plain ASCII needs no
+// memory at all and a stretch of CJK text a single run.
+type unitIndex struct {
+	unit Unit
+	runs []unitRun
+}
+
+// unitRun is n characters in a row of bl bytes and ul units each.
+type unitRun struct {
+	b, u   int64 // byte and unit offset of the first character
+	bl, ul int64
+	n      int64
+}
+
+func newUnitIndex(r io.Reader, unit Unit) (*unitIndex, error) {
+	x := &unitIndex{
+		unit: unit,
+	}
+	if unit == Byte {
+		return x, nil
+	}
+	reader := bufio.NewReader(r)
+	b, u := int64(0), int64(0)
+	for {
+		r, size, err := reader.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		l := runeLen(r, size, unit)
+		x.add(unitRun{b: b, u: u, bl: int64(size), ul: l, n: 1})
+		b += int64(size)
+		u += l
+	}
+	return x, nil
+}
+
+// add appends the characters of r, which must come after the ones indexed
+// so far, joining them with the last run when they continue it.
+func (x *unitIndex) add(r unitRun) {
+	if r.bl == r.ul || r.n == 0 {
+		return
+	}
+	if n := len(x.runs); n > 0 {
+		last := &x.runs[n-1]
+		if last.bl == r.bl && last.ul == r.ul && last.b+last.n*last.bl == r.b && last.u+last.n*last.ul == r.u {
+			last.n += r.n
+			return
+		}
+	}
+	x.runs = append(x.runs, r)
+}
+
+// apply returns the index of the text once the delta d, counting bytes,
+// applies to it. Only the inserted text is read, the rest of the index is
+// moved along.
+func (x *unitIndex) apply(d delta.Delta) *unitIndex {
+	y := &unitIndex{
+		unit: x.unit,
+	}
+	if x.unit == Byte {
+		return y
+	}
+	// p is the byte offset in the old text, q the one in the new text and
+	// shift what units and bytes differ by at q.
+	p, q, shift := int64(0), int64(0), int64(0)
+	i := 0
+	retain := func(n int64) {
+		for ; i < len(x.runs); i++ {
+			r := x.runs[i]
+			if r.b >= p+n {
+				break
+			}
+			// Only the characters between p and p+n are retained.
+			k0, k1 := int64(0), r.n
+			if r.b < p {
+				k0 = (p - r.b + r.bl - 1) / r.bl
+			}
+			if r.b+r.n*r.bl > p+n {
+				k1 = (p + n - r.b) / r.bl
+			}
+			if k0 < k1 {
+				b := q + r.b + k0*r.bl - p
+				y.add(unitRun{b: b, u: b + shift, bl: r.bl, ul: r.ul, n: k1 - k0})
+				shift += (k1 - k0) * (r.ul - r.bl)
+			}
+			if r.b+r.n*r.bl > p+n {
+				break
+			}
+		}
+		p += n
+		q += n
+	}
+	for _, op := range d.Ops {
+		switch {
+		case op.Retain != nil:
+			retain(int64(*op.Retain))
+		case op.Delete != nil:
+			p += int64(*op.Delete)
+			for i < len(x.runs) && x.runs[i].b+x.runs[i].n*x.runs[i].bl <= p {
+				i++
+			}
+		case op.InsertEmbed != nil:
+			q += 1
+		default:
+			for _, r := range op.Insert {
+				size := int64(utf8.RuneLen(r))
+				if size < 0 {
+					size = int64(len(string(r)))
+				}
+				l := runeLen(r, int(size), x.unit)
+				y.add(unitRun{b: q, u: q + shift, bl: size, ul: l, n: 1})
+				shift += l - size
+				q += size
+			}
+		}
+	}
+	if i < len(x.runs) {
+		last := x.runs[len(x.runs)-1]
+		retain(last.b + last.n*last.bl - p)
+	}
+	return y
+}
+
+// fromByte returns the position of byte offset p, an offset inside a
+// character maps to the start of it.
+func (x *unitIndex) fromByte(p int64) int64 {
+	i := sort.Search(len(x.runs), func(i int) bool {
+		return x.runs[i].b > p
+	})
+	if i == 0 {
+		return p
+	}
+	r := x.runs[i-1]
+	if end := r.b + r.n*r.bl; p >= end {
+		return r.u + r.n*r.ul + p - end
+	}
+	return r.u + (p-r.b)/r.bl*r.ul
+}
+
+// toByte returns the byte offset of position p.
+func (x *unitIndex) toByte(p int64) int64 {
+	i := sort.Search(len(x.runs), func(i int) bool {
+		return x.runs[i].u > p
+	})
+	if i == 0 {
+		return p
+	}
+	r := x.runs[i-1]
+	if end := r.u + r.n*r.ul; p >= end {
+		return r.b + r.n*r.bl + p - end
+	}
+	return r.b + (p-r.u)/r.ul*r.bl
+}
+
+// convertDelta changes the lengths of the retains and deletes in d with pos,
+// which maps positions of the document d applies to from one unit to
+// another. Inserts carry their own text and stay the same.
+func convertDelta(d delta.Delta, pos func(p int64) int64) delta.Delta {
+	result := delta.New(nil)
+	p := int64(0)
+	for _, op := range d.Ops {
+		if op.Retain == nil && op.Delete == nil {
+			result.Push(op)
+			continue
+		}
+		n := int64(op.Length())
+		l := int(pos(p+n) - pos(p))
+		p += n
+		if op.Delete != nil {
+			result.Delete(l)
+		} else {
+			result.Retain(l, op.Attributes)
+		}
+	}
+	return *result
+}